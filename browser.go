@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// browserConfig is how the browser is launched, on top of chromedp's
+// default options.
+type browserConfig struct {
+	// Path is the browser binary, chromedp looks for Chrome if it is empty.
+	Path string `json:"path,omitempty"`
+	// Flags are added to the command line, like --js-flags=--expose-gc.
+	Flags []string `json:"flags,omitempty"`
+	// RemoveFlags are removed from the default command line, by name.
+	RemoveFlags []string `json:"removeFlags,omitempty"`
+	// Headless is new or old to pick the headless mode, off to show the
+	// browser window, or empty for chromedp's default.
+	Headless headlessMode `json:"headless,omitempty"`
+	// WindowSize is the size of the window, like 1280x720.
+	WindowSize string `json:"windowSize,omitempty"`
+	// UserDataDir keeps the browser profile, a temporary one is used if
+	// it is empty.
+	UserDataDir string `json:"userDataDir,omitempty"`
+}
+
+// registerFlags defines flags which override the configuration, with names
+// starting with prefix. The repeatable flags add to the lists.
+func (bc *browserConfig) registerFlags(fs *flag.FlagSet, prefix string) {
+	fs.StringVar(&bc.Path, prefix+"browser", bc.Path, "path of the browser binary")
+	fs.Var((*stringList)(&bc.Flags), prefix+"browser-flag", "browser flag to add, like --js-flags=--expose-gc (repeatable)")
+	fs.Var((*stringList)(&bc.RemoveFlags), prefix+"remove-browser-flag", "name of a default browser flag to remove (repeatable)")
+	fs.Var(&bc.Headless, prefix+"headless", "headless mode: new, old or off")
+	fs.StringVar(&bc.WindowSize, prefix+"window-size", bc.WindowSize, "window size, like 1280x720")
+	fs.StringVar(&bc.UserDataDir, prefix+"user-data-dir", bc.UserDataDir, "directory of a persistent browser profile")
+}
+
+// stringList is a flag which can be repeated.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, " ")
+}
+
+func (sl *stringList) Set(s string) error {
+	*sl = append(*sl, s)
+	return nil
+}
+
+// headlessMode is new, old or off. Empty, on, true and 1 keep chromedp's
+// default.
+type headlessMode string
+
+func (hm headlessMode) String() string {
+	return string(hm)
+}
+
+func (hm *headlessMode) Set(s string) error {
+	switch s {
+	case "", "new", "old", "off", "on", "true", "1":
+		*hm = headlessMode(s)
+		return nil
+	}
+	return fmt.Errorf("invalid headless mode %q, expected new, old or off", s)
+}
+
+func (hm *headlessMode) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return err
+	}
+	return hm.Set(s)
+}
+
+// chromeFlags returns the flags to set on the command line, by name. A
+// value of true is a flag without value, false removes the flag.
+func (bc *browserConfig) chromeFlags() (map[string]interface{}, error) {
+	flags := make(map[string]interface{})
+	switch bc.Headless {
+	case "new", "old":
+		flags["headless"] = string(bc.Headless)
+	case "off":
+		flags["headless"] = false
+	}
+	if bc.WindowSize != "" {
+		w, h, ok := strings.Cut(bc.WindowSize, "x")
+		width, werr := strconv.Atoi(w)
+		height, herr := strconv.Atoi(h)
+		if !ok || werr != nil || herr != nil || width <= 0 || height <= 0 {
+			return nil, fmt.Errorf("invalid window size %q, expected WIDTHxHEIGHT", bc.WindowSize)
+		}
+		flags["window-size"] = fmt.Sprintf("%d,%d", width, height)
+	}
+	if bc.UserDataDir != "" {
+		flags["user-data-dir"] = bc.UserDataDir
+	}
+	for _, f := range bc.Flags {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(f, "-"), "=")
+		if name == "" {
+			return nil, fmt.Errorf("invalid browser flag %q", f)
+		}
+		if hasValue {
+			flags[name] = value
+		} else {
+			flags[name] = true
+		}
+	}
+	for _, f := range bc.RemoveFlags {
+		name, _, _ := strings.Cut(strings.TrimLeft(f, "-"), "=")
+		flags[name] = false
+	}
+	return flags, nil
+}
+
+// allocatorOptions returns the options which apply the configuration.
+func (bc *browserConfig) allocatorOptions() ([]chromedp.ExecAllocatorOption, error) {
+	flags, err := bc.chromeFlags()
+	if err != nil {
+		return nil, err
+	}
+	var opts []chromedp.ExecAllocatorOption
+	if bc.Path != "" {
+		opts = append(opts, chromedp.ExecPath(bc.Path))
+	}
+	for name, value := range flags {
+		opts = append(opts, chromedp.Flag(name, value))
+	}
+	return opts, nil
+}
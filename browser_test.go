@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBrowserConfig(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		config      browserConfig
+		expected    map[string]interface{}
+		expectErr   bool
+	}{
+		{
+			description: "default",
+			expected:    map[string]interface{}{},
+		},
+		{
+			description: "all settings",
+			config: browserConfig{
+				Flags:       []string{"--js-flags=--expose-gc --trace-gc", "disable-background-timer-throttling"},
+				RemoveFlags: []string{"--mute-audio", "disable-gpu"},
+				Headless:    "new",
+				WindowSize:  "1280x720",
+				UserDataDir: "/tmp/profile",
+			},
+			expected: map[string]interface{}{
+				"js-flags":                            "--expose-gc --trace-gc",
+				"disable-background-timer-throttling": true,
+				"mute-audio":                          false,
+				"disable-gpu":                         false,
+				"headless":                            "new",
+				"window-size":                         "1280,720",
+				"user-data-dir":                       "/tmp/profile",
+			},
+		},
+		{
+			description: "headless off",
+			config:      browserConfig{Headless: "off"},
+			expected:    map[string]interface{}{"headless": false},
+		},
+		{
+			description: "flags win over settings",
+			config:      browserConfig{Headless: "old", Flags: []string{"--headless"}},
+			expected:    map[string]interface{}{"headless": true},
+		},
+		{
+			description: "on, true and 1 keep the default headless mode",
+			config:      browserConfig{Headless: "true"},
+			expected:    map[string]interface{}{},
+		},
+		{
+			description: "invalid window size",
+			config:      browserConfig{WindowSize: "1280,720"},
+			expectErr:   true,
+		},
+		{
+			description: "invalid flag",
+			config:      browserConfig{Flags: []string{"--=x"}},
+			expectErr:   true,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			flags, err := tc.config.chromeFlags()
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(flags, tc.expected) {
+				t.Errorf("got %v, expected %v", flags, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"time"
+)
+
+// ephemeralCerts is a certificate authority and a localhost certificate
+// signed by it, which only live as long as one run.
+type ephemeralCerts struct {
+	ca   *x509.Certificate
+	leaf tls.Certificate
+}
+
+// certLifetime is long enough for any test run, the certificates are never
+// reused.
+const certLifetime = 24 * time.Hour
+
+func newEphemeralCerts() (*ephemeralCerts, error) {
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "wasmbrowsertest ephemeral CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(certLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(certLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ephemeralCerts{
+		ca: ca,
+		leaf: tls.Certificate{
+			Certificate: [][]byte{leafDER, caDER},
+			PrivateKey:  leafKey,
+			Leaf:        leaf,
+		},
+	}, nil
+}
+
+func randomSerial() *big.Int {
+	// rand.Int only fails if the reader fails, which crypto/rand never does.
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+// tlsConfig serves the localhost certificate.
+func (c *ephemeralCerts) tlsConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{c.leaf},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// spkiHashes returns the base64 SHA-256 hashes of the public keys, as
+// Chrome's --ignore-certificate-errors-spki-list expects them. Chrome then
+// trusts these certificates only, other certificate errors still fail.
+func (c *ephemeralCerts) spkiHashes() []string {
+	var hashes []string
+	for _, cert := range []*x509.Certificate{c.ca, c.leaf.Leaf} {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		hashes = append(hashes, base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	return hashes
+}
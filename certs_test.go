@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEphemeralCerts(t *testing.T) {
+	certs, err := newEphemeralCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(certs.ca)
+	for _, name := range []string{"localhost", "127.0.0.1", "::1"} {
+		_, err := certs.leaf.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+		if err != nil {
+			t.Errorf("certificate is not valid for %s: %v", name, err)
+		}
+	}
+	if _, err := certs.leaf.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err == nil {
+		t.Error("certificate is valid for example.com")
+	}
+
+	hashes := certs.spkiHashes()
+	sum := sha256.Sum256(certs.ca.RawSubjectPublicKeyInfo)
+	if len(hashes) != 2 || hashes[0] != base64.StdEncoding.EncodeToString(sum[:]) || hashes[0] == hashes[1] {
+		t.Errorf("unexpected SPKI hashes %v", hashes)
+	}
+
+	other, err := newEphemeralCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.spkiHashes()[0] == hashes[0] {
+		t.Error("two runs share a certificate authority")
+	}
+}
+
+func TestStartHTTPServer_TLS(t *testing.T) {
+	certs, err := newEphemeralCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	url, shutdown, err := startHTTPServer(context.Background(), handler, log.New(io.Discard, "", 0), certs.tlsConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	if !strings.HasPrefix(url, "https://") {
+		t.Fatalf("expected an https url, got %s", url)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certs.ca)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("status %d, tls %v", resp.StatusCode, resp.TLS)
+	}
+
+	// A client which does not trust the run's authority is refused.
+	if _, err := http.Get(url); err == nil {
+		t.Error("expected a certificate error with the system roots")
+	}
+}
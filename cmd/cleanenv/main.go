@@ -6,6 +6,16 @@
 //	cleanenv -remove-prefix GITHUB_ -- go test -cover ./...
 //
 // The '-remove-prefix' flag can be repeated multiple times to remove even more environment variables.
+//
+// Alternatively, '-keep' and '-keep-prefix' keep only the given variables:
+//
+//	cleanenv -keep-prefix GO -keep HOME -keep PATH -- go test -cover ./...
+//
+// '-remove-regex' and '-unset' remove more variables, then '-env-file' and '-set' add variables.
+// All the flags can be repeated.
+//
+// To find what to remove, '-report' prints the size of the environment against the Go wasm limit,
+// and suggests '-remove-prefix' flags which get under it. '-auto' applies them before running the command.
 package main
 
 import (
@@ -15,7 +25,11 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"regexp"
+	"slices"
 	"strings"
+
+	"github.com/agnivade/wasmbrowsertest/internal/wasmenv"
 )
 
 func main() {
@@ -45,17 +59,35 @@ type App struct {
 
 func (a App) Run() error {
 	set := flag.NewFlagSet("cleanenv", flag.ContinueOnError)
-	var removePrefixes StringSliceFlag
-	set.Var(&removePrefixes, "remove-prefix", "Remove one or more environment variables with the given prefixes.")
+	var rules Rules
+	set.Var(&rules.KeepPrefixes, "keep-prefix", "Keep only the environment variables with the given prefixes, and those kept by -keep.")
+	set.Var(&rules.Keep, "keep", "Keep only the environment variables with the given names, and those kept by -keep-prefix.")
+	set.Var(&rules.RemovePrefixes, "remove-prefix", "Remove one or more environment variables with the given prefixes.")
+	set.Var(&rules.RemoveRegexes, "remove-regex", "Remove the environment variables with names matching the given regular expression.")
+	set.Var(&rules.Unset, "unset", "Remove the environment variable with the given name.")
+	set.Var(&rules.EnvFiles, "env-file", "Add the KEY=VALUE lines of the given file to the environment.")
+	set.Var(&rules.Set, "set", "Add KEY=VALUE to the environment.")
+	report := set.Bool("report", false, "Print the size of the environment against the Go wasm limit, its largest variables and prefixes, and the prefixes to remove, instead of running the command.")
+	auto := set.Bool("auto", false, "Remove the prefixes -report suggests, if the environment does not fit the Go wasm limit.")
 	if err := set.Parse(a.Args); err != nil {
 		return err
 	}
 
-	var cleanEnv []string
-	for _, keyValue := range a.Env {
-		tokens := strings.SplitN(keyValue, "=", 2)
-		if allowEnvName(tokens[0], removePrefixes) {
-			cleanEnv = append(cleanEnv, keyValue)
+	cleanEnv, err := rules.Apply(a.Env)
+	if err != nil {
+		return err
+	}
+	if *report {
+		return writeReport(a.StdOut, cleanEnv, rules.protects)
+	}
+	if *auto {
+		prefixes, err := suggestPrefixes(cleanEnv, rules.protects)
+		if err != nil {
+			return fmt.Errorf("the environment does not fit the Go wasm limit: %w", err)
+		}
+		if len(prefixes) > 0 {
+			fmt.Fprintf(a.ErrOut, "cleanenv: the environment does not fit the Go wasm limit, applying %s\n", removeFlags(prefixes))
+			cleanEnv = removePrefixes(cleanEnv, prefixes)
 		}
 	}
 
@@ -70,6 +102,117 @@ func (a App) Run() error {
 	return cmd.Run()
 }
 
+// Rules change the environment, in this order:
+//
+//  1. If Keep or KeepPrefixes are set, only the variables they match are kept.
+//  2. The variables matching RemovePrefixes, RemoveRegexes or Unset are removed.
+//  3. The variables of EnvFiles are added, in order.
+//  4. The variables of Set are added, in order.
+//
+// An added variable replaces the variable of the same name.
+type Rules struct {
+	Keep, KeepPrefixes            StringSliceFlag
+	RemovePrefixes, RemoveRegexes StringSliceFlag
+	Unset                         StringSliceFlag
+	EnvFiles                      StringSliceFlag
+	Set                           StringSliceFlag
+}
+
+// Apply returns env changed by the rules. Regular expressions match whole
+// names.
+func (r Rules) Apply(env []string) ([]string, error) {
+	var removeRegexes []*regexp.Regexp
+	for _, expr := range r.RemoveRegexes {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid -remove-regex: %w", err)
+		}
+		removeRegexes = append(removeRegexes, re)
+	}
+
+	var cleanEnv []string
+	for _, keyValue := range env {
+		tokens := strings.SplitN(keyValue, "=", 2)
+		name := tokens[0]
+		if (len(r.Keep) > 0 || len(r.KeepPrefixes) > 0) && !slices.Contains(r.Keep, name) && !hasAnyPrefix(name, r.KeepPrefixes) {
+			continue
+		}
+		if !allowEnvName(name, r.RemovePrefixes) || slices.Contains(r.Unset, name) || matchesAny(name, removeRegexes) {
+			continue
+		}
+		cleanEnv = append(cleanEnv, keyValue)
+	}
+
+	for _, path := range r.EnvFiles {
+		vars, err := readEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, keyValue := range vars {
+			cleanEnv = setEnv(cleanEnv, keyValue)
+		}
+	}
+	for _, keyValue := range r.Set {
+		if name, _, ok := strings.Cut(keyValue, "="); !ok || name == "" {
+			return nil, fmt.Errorf("invalid -set %q, expected KEY=VALUE", keyValue)
+		}
+		cleanEnv = setEnv(cleanEnv, keyValue)
+	}
+	return cleanEnv, nil
+}
+
+// protects reports whether the variable name is named by the rules or
+// commonly needed, so -auto never removes it.
+func (r Rules) protects(name string) bool {
+	if wasmenv.IsCommon(name) || slices.Contains(r.Keep, name) || hasAnyPrefix(name, r.KeepPrefixes) {
+		return true
+	}
+	return slices.ContainsFunc(r.Set, func(keyValue string) bool {
+		return strings.HasPrefix(keyValue, name+"=")
+	})
+}
+
+// readEnvFile reads the KEY=VALUE lines of a file. Empty lines and lines
+// starting with # are skipped, a leading "export " and quotes around the
+// value are removed.
+func readEnvFile(path string) ([]string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vars []string
+	for i, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, i+1)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars = append(vars, name+"="+value)
+	}
+	return vars, nil
+}
+
+// setEnv replaces the variable of keyValue in env, or adds it.
+func setEnv(env []string, keyValue string) []string {
+	name, _, _ := strings.Cut(keyValue, "=")
+	for i, kv := range env {
+		if strings.HasPrefix(kv, name+"=") {
+			env[i] = keyValue
+			return env
+		}
+	}
+	return append(env, keyValue)
+}
+
 type StringSliceFlag []string
 
 func (s *StringSliceFlag) Set(value string) error {
@@ -81,6 +224,24 @@ func (s *StringSliceFlag) String() string {
 	return strings.Join(*s, ", ")
 }
 
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(name string, regexes []*regexp.Regexp) bool {
+	for _, re := range regexes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
 func allowEnvName(name string, removePrefixes []string) bool {
 	for _, prefix := range removePrefixes {
 		if strings.HasPrefix(name, prefix) {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"os"
 	"strings"
 	"testing"
 )
@@ -68,6 +69,155 @@ func TestRun(t *testing.T) {
 			},
 			expectOutput: "CLEAN_BAZ=baz",
 		},
+		{
+			name: "keep prefixes and names",
+			env: []string{
+				"CLEAN_BAR=bar",
+				"CLEAN_BAZ=baz",
+				"CLEAN_FOO=foo",
+				"PATH=" + os.Getenv("PATH"),
+			},
+			args: []string{
+				"-keep-prefix=CLEAN_BA",
+				"-keep=CLEAN_FOO",
+				"-keep=PATH", "--",
+				"bash", "-c", bashPrintCleanVars,
+			},
+			expectOutput: "CLEAN_BAR=bar CLEAN_BAZ=baz CLEAN_FOO=foo",
+		},
+		{
+			name: "keep only the given name",
+			env: []string{
+				"CLEAN_BAR=bar",
+				"CLEAN_FOO=foo",
+				"PATH=" + os.Getenv("PATH"),
+			},
+			args: []string{
+				"-keep=CLEAN_FOO",
+				"-keep=PATH", "--",
+				"bash", "-c", bashPrintCleanVars,
+			},
+			expectOutput: "CLEAN_FOO=foo",
+		},
+		{
+			name: "remove wins over keep",
+			env: []string{
+				"CLEAN_BAR=bar",
+				"CLEAN_FOO=foo",
+				"PATH=" + os.Getenv("PATH"),
+			},
+			args: []string{
+				"-keep-prefix=CLEAN_",
+				"-keep=PATH",
+				"-remove-prefix=CLEAN_BAR", "--",
+				"bash", "-c", bashPrintCleanVars,
+			},
+			expectOutput: "CLEAN_FOO=foo",
+		},
+		{
+			name: "remove regex and unset",
+			env: []string{
+				"CLEAN_BAR=bar",
+				"CLEAN_BAZ=baz",
+				"CLEAN_FOO=foo",
+				"CLEAN_FOO_BAR=foobar",
+			},
+			args: []string{
+				"-remove-regex=CLEAN_BA.",
+				"-unset=CLEAN_FOO", "--",
+				"bash", "-c", bashPrintCleanVars,
+			},
+			expectOutput: "CLEAN_FOO_BAR=foobar",
+		},
+		{
+			name: "env file",
+			env: []string{
+				"CLEAN_BAR=bar",
+				"CLEAN_FOO=foo",
+			},
+			args: []string{
+				"-env-file=testdata/test.env", "--",
+				"bash", "-c", bashPrintCleanVars,
+			},
+			expectOutput: "CLEAN_BAR=bar CLEAN_EXPORTED=exported value CLEAN_FILE=file CLEAN_FOO=from file",
+		},
+		{
+			name: "set wins over env file",
+			env: []string{
+				"CLEAN_BAR=bar",
+			},
+			args: []string{
+				"-set=CLEAN_FILE=set",
+				"-env-file=testdata/test.env",
+				"-set=CLEAN_EMPTY=", "--",
+				"bash", "-c", bashPrintCleanVars,
+			},
+			expectOutput: "CLEAN_BAR=bar CLEAN_EMPTY= CLEAN_EXPORTED=exported value CLEAN_FILE=set CLEAN_FOO=from file",
+		},
+		{
+			name: "set after keep and remove",
+			env: []string{
+				"CLEAN_BAR=bar",
+				"CLEAN_FOO=foo",
+				"PATH=" + os.Getenv("PATH"),
+			},
+			args: []string{
+				"-keep=PATH",
+				"-unset=CLEAN_FOO",
+				"-set=CLEAN_FOO=set", "--",
+				"bash", "-c", bashPrintCleanVars,
+			},
+			expectOutput: "CLEAN_FOO=set",
+		},
+		{
+			name: "auto removes prefixes to fit",
+			env: []string{
+				"CLEAN_BIG_A=" + strings.Repeat("a", 4000),
+				"CLEAN_BIG_B=" + strings.Repeat("b", 4000),
+				"CLEAN_SMALL=small",
+			},
+			args: []string{
+				"-auto",
+				"-set=CLEAN_SMALL=s", "--",
+				"bash", "-c", bashPrintCleanVars,
+			},
+			expectOutput: "cleanenv: the environment does not fit the Go wasm limit, applying -remove-prefix CLEAN_BIG_\nCLEAN_SMALL=s",
+		},
+		{
+			name: "auto keeps an environment which fits",
+			env: []string{
+				"CLEAN_BAR=bar",
+				"CLEAN_FOO=foo",
+			},
+			args: []string{
+				"-auto", "--",
+				"bash", "-c", bashPrintCleanVars,
+			},
+			expectOutput: "CLEAN_BAR=bar CLEAN_FOO=foo",
+		},
+		{
+			name: "auto cannot fit protected variables",
+			env: []string{
+				"CLEAN_BIG=" + strings.Repeat("a", 8000),
+			},
+			args:      []string{"-auto", "-keep=CLEAN_BIG", "--", "true"},
+			expectErr: "the environment does not fit the Go wasm limit: no prefixes get under the limit without removing protected variables",
+		},
+		{
+			name:      "invalid set",
+			args:      []string{"-set=CLEAN_FOO", "--", "true"},
+			expectErr: `invalid -set "CLEAN_FOO", expected KEY=VALUE`,
+		},
+		{
+			name:      "invalid regex",
+			args:      []string{"-remove-regex=CLEAN_(", "--", "true"},
+			expectErr: "invalid -remove-regex: error parsing regexp: missing closing ): `^(?:CLEAN_()$`",
+		},
+		{
+			name:      "invalid env file",
+			args:      []string{"-env-file=testdata/invalid.env", "--", "true"},
+			expectErr: "testdata/invalid.env:2: expected KEY=VALUE",
+		},
 	} {
 		tc := tc // enable parallel sub-tests
 		t.Run(tc.name, func(t *testing.T) {
@@ -93,6 +243,95 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestReport(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name         string
+		env          []string
+		args         []string
+		expectOutput string
+	}{
+		{
+			name: "fits",
+			env: []string{
+				"GOFLAGS=-mod=mod",
+				"HOME=/home/gopher",
+				"CLEAN_BAR=bar",
+			},
+			args: []string{"-report"},
+			expectOutput: `environment: 104 of 8192 bytes, fits (1024 bytes are left for the arguments)
+largest variables:
+  GOFLAGS    32 bytes
+  HOME       32 bytes
+  CLEAN_BAR  24 bytes
+largest prefixes:
+  CLEAN_  24 bytes in 1 variables
+suggestion: none, the environment fits
+`,
+		},
+		{
+			name: "over the limit",
+			env: []string{
+				"GOFLAGS=-mod=mod",
+				"GITHUB_EVENT_PATH=" + strings.Repeat("e", 3000),
+				"GITHUB_SHA=" + strings.Repeat("s", 2000),
+				"RUNNER_TOOL_CACHE=" + strings.Repeat("r", 2500),
+				"RUNNER_TEMP=/tmp",
+				"LS_COLORS=" + strings.Repeat("c", 1000),
+			},
+			args: []string{"-report", "-unset=LS_COLORS", "--", "go", "test"},
+			expectOutput: `environment: 7664 of 8192 bytes, leaves less than the room for the arguments (1024 bytes are left for the arguments)
+largest variables:
+  GITHUB_EVENT_PATH  3032 bytes
+  RUNNER_TOOL_CACHE  2528 bytes
+  GITHUB_SHA         2024 bytes
+  GOFLAGS            32 bytes
+  RUNNER_TEMP        32 bytes
+largest prefixes:
+  GITHUB_  5056 bytes in 2 variables
+  RUNNER_  2560 bytes in 2 variables
+suggestion: -remove-prefix GITHUB_
+`,
+		},
+		{
+			name: "protected variables",
+			env: []string{
+				"GOFLAGS=" + strings.Repeat("g", 3000),
+				"CLEAN_A=" + strings.Repeat("a", 3000),
+				"CLEAN_B=" + strings.Repeat("b", 3000),
+			},
+			args: []string{"-report", "-set=CLEAN_A=" + strings.Repeat("a", 3000)},
+			expectOutput: `environment: 9088 of 8192 bytes, over the limit (1024 bytes are left for the arguments)
+largest variables:
+  CLEAN_A  3024 bytes
+  CLEAN_B  3024 bytes
+  GOFLAGS  3024 bytes
+largest prefixes:
+  CLEAN_  6048 bytes in 2 variables
+suggestion: -remove-prefix CLEAN_B
+`,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var output bytes.Buffer
+			app := App{
+				Args:   tc.args,
+				Env:    tc.env,
+				StdOut: &output,
+				ErrOut: &output,
+			}
+			if err := app.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != tc.expectOutput {
+				t.Errorf("Unexpected output:\n%s\nexpected:\n%s", output.String(), tc.expectOutput)
+			}
+		})
+	}
+}
+
 func assertEqualError(t *testing.T, expected string, err error) {
 	t.Helper()
 	if expected == "" {
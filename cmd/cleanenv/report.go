@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/agnivade/wasmbrowsertest/internal/wasmenv"
+)
+
+// envBudget is the size the environment should stay under, leaving room
+// for the arguments of a test binary.
+const envBudget = wasmenv.Limit - wasmenv.ArgsReserve
+
+// sizeEntry is the size of a variable or a prefix.
+type sizeEntry struct {
+	name  string
+	size  int
+	count int
+}
+
+// writeReport writes the size of env against the limit of wasm_exec.js, its
+// largest variables and prefixes, and the prefixes to remove to fit.
+func writeReport(w io.Writer, env []string, protected func(name string) bool) error {
+	total := wasmenv.Total(nil, env)
+	verdict := "fits"
+	if total >= wasmenv.Limit {
+		verdict = "over the limit"
+	} else if total >= envBudget {
+		verdict = "leaves less than the room for the arguments"
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "environment: %d of %d bytes, %s (%d bytes are left for the arguments)\n", total, wasmenv.Limit, verdict, wasmenv.ArgsReserve)
+
+	fmt.Fprintf(tw, "largest variables:\n")
+	var vars []sizeEntry
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		vars = append(vars, sizeEntry{name: name, size: wasmenv.Size(kv), count: 1})
+	}
+	for _, e := range largest(vars, 10) {
+		fmt.Fprintf(tw, "  %s\t%d bytes\n", e.name, e.size)
+	}
+
+	fmt.Fprintf(tw, "largest prefixes:\n")
+	byPrefix := make(map[string]*sizeEntry)
+	for _, v := range vars {
+		prefix, _, ok := strings.Cut(v.name, "_")
+		if !ok {
+			continue
+		}
+		prefix += "_"
+		if byPrefix[prefix] == nil {
+			byPrefix[prefix] = &sizeEntry{name: prefix}
+		}
+		byPrefix[prefix].size += v.size
+		byPrefix[prefix].count++
+	}
+	var prefixes []sizeEntry
+	for _, e := range byPrefix {
+		prefixes = append(prefixes, *e)
+	}
+	for _, e := range largest(prefixes, 10) {
+		fmt.Fprintf(tw, "  %s\t%d bytes in %d variables\n", e.name, e.size, e.count)
+	}
+
+	suggested, err := suggestPrefixes(env, protected)
+	switch {
+	case err != nil:
+		fmt.Fprintf(tw, "suggestion: none, %v\n", err)
+	case len(suggested) == 0:
+		fmt.Fprintf(tw, "suggestion: none, the environment fits\n")
+	default:
+		fmt.Fprintf(tw, "suggestion: %s\n", removeFlags(suggested))
+	}
+	return tw.Flush()
+}
+
+// largest returns the n largest entries, by size then name.
+func largest(entries []sizeEntry, n int) []sizeEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].size != entries[j].size {
+			return entries[i].size > entries[j].size
+		}
+		return entries[i].name < entries[j].name
+	})
+	return entries[:min(n, len(entries))]
+}
+
+// suggestPrefixes returns prefixes to remove for env to fit in envBudget.
+// It picks the prefix which removes the most bytes until env fits, so the
+// set is small, though not always the smallest. Prefixes end at an
+// underscore, or are whole names. A prefix which would remove a protected
+// variable is never picked.
+func suggestPrefixes(env []string, protected func(name string) bool) ([]string, error) {
+	var names []string
+	sizes := make(map[string]int)
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		names = append(names, name)
+		sizes[name] = wasmenv.Size(kv)
+	}
+	covers := func(prefix string, names []string) []string {
+		var covered []string
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				covered = append(covered, name)
+			}
+		}
+		return covered
+	}
+
+	var candidates []string
+	for _, name := range names {
+		for i := 1; i < len(name); i++ {
+			if name[i] == '_' {
+				candidates = append(candidates, name[:i+1])
+			}
+		}
+		candidates = append(candidates, name)
+	}
+	slices.Sort(candidates)
+	candidates = slices.Compact(candidates)
+	candidates = slices.DeleteFunc(candidates, func(prefix string) bool {
+		return slices.ContainsFunc(covers(prefix, names), protected)
+	})
+
+	total := wasmenv.Total(nil, env)
+	var picked []string
+	for total >= envBudget {
+		best, bestSize := "", 0
+		for _, prefix := range candidates {
+			size := 0
+			for _, name := range covers(prefix, names) {
+				size += sizes[name]
+			}
+			// For the same size, the longer prefix says more precisely
+			// what is removed.
+			if size > bestSize || size == bestSize && size > 0 && len(prefix) > len(best) {
+				best, bestSize = prefix, size
+			}
+		}
+		if bestSize == 0 {
+			return nil, errors.New("no prefixes get under the limit without removing protected variables")
+		}
+		picked = append(picked, best)
+		total -= bestSize
+		names = slices.DeleteFunc(names, func(name string) bool { return strings.HasPrefix(name, best) })
+	}
+	return picked, nil
+}
+
+// removePrefixes returns env without the variables with the prefixes.
+func removePrefixes(env []string, prefixes []string) []string {
+	var kept []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if allowEnvName(name, prefixes) {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+func removeFlags(prefixes []string) string {
+	var flags []string
+	for _, prefix := range prefixes {
+		flags = append(flags, "-remove-prefix "+prefix)
+	}
+	return strings.Join(flags, " ")
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// configFileName is looked up from the working directory, which is the
+// package directory under go test, up to the root.
+const configFileName = ".wasmbrowsertest.json"
+
+// config is the configuration of a run. The defaults are overridden by the
+// config file, then by the WASM_* environment variables, then by the
+// -wbt.* flags.
+type config struct {
+	Browser   browserConfig   `json:"browser"`
+	Server    serverConfig    `json:"server"`
+	Artifacts artifactsConfig `json:"artifacts"`
+	Env       envConfig       `json:"env"`
+	// Timeout ends the run if the program did not exit by then.
+	Timeout duration `json:"timeout,omitempty"`
+
+	// file is the config file, if one was read.
+	file string
+}
+
+// serverConfig is what the wasm server serves, and how.
+type serverConfig struct {
+	IndexTemplate     string            `json:"indexTemplate,omitempty"`
+	WasmExecJS        string            `json:"wasmExecJS,omitempty"`
+	TinyGoRoot        string            `json:"tinygoRoot,omitempty"`
+	ToolchainDownload bool              `json:"toolchainDownload,omitempty"`
+	Preload           []string          `json:"preload,omitempty"`
+	Static            map[string]string `json:"static,omitempty"`
+	Proxy             map[string]string `json:"proxy,omitempty"`
+	ProxyLog          bool              `json:"proxyLog,omitempty"`
+	SecurityProfiles  []string          `json:"securityProfiles,omitempty"`
+	Headers           string            `json:"headers,omitempty"`
+	HTTPS             bool              `json:"https,omitempty"`
+	CORSAllow         []string          `json:"corsAllow,omitempty"`
+	Mocks             string            `json:"mocks,omitempty"`
+	Sidecars          string            `json:"sidecars,omitempty"`
+}
+
+// artifactsConfig are the files a run writes.
+type artifactsConfig struct {
+	FSTrace string `json:"fsTrace,omitempty"`
+	HAR     string `json:"har,omitempty"`
+	HARFS   bool   `json:"harFS,omitempty"`
+}
+
+// loadConfig reads the config file and the environment. WASM_CONFIG names
+// the config file, or turns the lookup off with "off".
+func loadConfig() (config, error) {
+	var c config
+	path := os.Getenv("WASM_CONFIG")
+	if path == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return c, err
+		}
+		path, err = findConfigFile(wd)
+		if err != nil {
+			return c, err
+		}
+	}
+	if path != "" && path != "off" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return c, err
+		}
+		c.merge(file)
+		c.file = path
+	}
+	if err := c.applyEnv(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// configure applies sc to the server.
+func (ws *wasmServer) configure(sc serverConfig) error {
+	if sc.IndexTemplate != "" {
+		if err := ws.setIndexTemplate(sc.IndexTemplate); err != nil {
+			return err
+		}
+	}
+	if len(sc.Preload) > 0 {
+		if err := ws.setPreloads(sc.Preload); err != nil {
+			return err
+		}
+	}
+	if err := ws.addStaticDirs(mountsOf(sc.Static)); err != nil {
+		return err
+	}
+	if err := ws.addProxies(mountsOf(sc.Proxy), sc.ProxyLog); err != nil {
+		return err
+	}
+	if err := ws.setSecurityProfiles(sc.SecurityProfiles); err != nil {
+		return err
+	}
+	if sc.Headers != "" {
+		if err := ws.readHeadersFile(sc.Headers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findConfigFile returns the config file in dir or its closest parent, or
+// an empty path if there is none.
+func findConfigFile(dir string) (string, error) {
+	for {
+		path := filepath.Join(dir, configFileName)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// readConfigFile reads a config file. Its relative paths are relative to
+// the directory of the file.
+func readConfigFile(path string) (config, error) {
+	var c config
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return c, fmt.Errorf("error reading config file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p *string) {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	// A browser given by name is looked up in PATH.
+	if strings.ContainsRune(c.Browser.Path, filepath.Separator) || strings.ContainsRune(c.Browser.Path, '/') {
+		resolve(&c.Browser.Path)
+	}
+	resolve(&c.Browser.UserDataDir)
+	resolve(&c.Server.IndexTemplate)
+	resolve(&c.Server.WasmExecJS)
+	resolve(&c.Server.TinyGoRoot)
+	for i := range c.Server.Preload {
+		resolve(&c.Server.Preload[i])
+	}
+	for prefix, target := range c.Server.Static {
+		resolve(&target)
+		c.Server.Static[prefix] = target
+	}
+	resolve(&c.Server.Headers)
+	resolve(&c.Server.Mocks)
+	resolve(&c.Server.Sidecars)
+	resolve(&c.Artifacts.FSTrace)
+	resolve(&c.Artifacts.HAR)
+	return c, nil
+}
+
+// merge overrides c with the settings of o which are set.
+func (c *config) merge(o config) {
+	setString := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	setStrings := func(dst *[]string, src []string) {
+		if src != nil {
+			*dst = src
+		}
+	}
+	setMap := func(dst *map[string]string, src map[string]string) {
+		if src != nil {
+			*dst = src
+		}
+	}
+	setBool := func(dst *bool, src bool) {
+		if src {
+			*dst = src
+		}
+	}
+
+	setString(&c.Browser.Path, o.Browser.Path)
+	setStrings(&c.Browser.Flags, o.Browser.Flags)
+	setStrings(&c.Browser.RemoveFlags, o.Browser.RemoveFlags)
+	if o.Browser.Headless != "" {
+		c.Browser.Headless = o.Browser.Headless
+	}
+	setString(&c.Browser.WindowSize, o.Browser.WindowSize)
+	setString(&c.Browser.UserDataDir, o.Browser.UserDataDir)
+
+	setString(&c.Server.IndexTemplate, o.Server.IndexTemplate)
+	setString(&c.Server.WasmExecJS, o.Server.WasmExecJS)
+	setString(&c.Server.TinyGoRoot, o.Server.TinyGoRoot)
+	setBool(&c.Server.ToolchainDownload, o.Server.ToolchainDownload)
+	setStrings(&c.Server.Preload, o.Server.Preload)
+	setMap(&c.Server.Static, o.Server.Static)
+	setMap(&c.Server.Proxy, o.Server.Proxy)
+	setBool(&c.Server.ProxyLog, o.Server.ProxyLog)
+	setStrings(&c.Server.SecurityProfiles, o.Server.SecurityProfiles)
+	setString(&c.Server.Headers, o.Server.Headers)
+	setBool(&c.Server.HTTPS, o.Server.HTTPS)
+	setStrings(&c.Server.CORSAllow, o.Server.CORSAllow)
+	setString(&c.Server.Mocks, o.Server.Mocks)
+	setString(&c.Server.Sidecars, o.Server.Sidecars)
+
+	setString(&c.Artifacts.FSTrace, o.Artifacts.FSTrace)
+	setString(&c.Artifacts.HAR, o.Artifacts.HAR)
+	setBool(&c.Artifacts.HARFS, o.Artifacts.HARFS)
+
+	setString(&c.Env.Forward, o.Env.Forward)
+	setStrings(&c.Env.Keep, o.Env.Keep)
+	setStrings(&c.Env.Remove, o.Env.Remove)
+	setStrings(&c.Env.KeepRegex, o.Env.KeepRegex)
+	setStrings(&c.Env.RemoveRegex, o.Env.RemoveRegex)
+	setString(&c.Env.Secrets, o.Env.Secrets)
+
+	if o.Timeout != 0 {
+		c.Timeout = o.Timeout
+	}
+}
+
+// applyEnv overrides c with the WASM_* variables which are set.
+func (c *config) applyEnv() error {
+	var errs []error
+	str := func(dst *string, name string) {
+		if v := os.Getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	list := func(dst *[]string, name string, split func(string) []string) {
+		if v := os.Getenv(name); v != "" {
+			*dst = split(v)
+		}
+	}
+	mounts := func(dst *map[string]string, name string) {
+		if v := os.Getenv(name); v != "" {
+			m, err := parseMounts(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = mountMap(m)
+		}
+	}
+	onOff := func(dst *bool, name string) {
+		switch v := os.Getenv(name); v {
+		case "":
+		case "on":
+			*dst = true
+		case "off":
+			*dst = false
+		default:
+			errs = append(errs, fmt.Errorf("%s is %q, expected on or off", name, v))
+		}
+	}
+	value := func(dst flag.Value, name string) {
+		if v := os.Getenv(name); v != "" {
+			if err := dst.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	commaList := func(s string) []string {
+		return strings.Split(s, ",")
+	}
+
+	str(&c.Browser.Path, "WASM_BROWSER")
+	list(&c.Browser.Flags, "WASM_BROWSER_FLAGS", strings.Fields)
+	list(&c.Browser.RemoveFlags, "WASM_BROWSER_REMOVE_FLAGS", strings.Fields)
+	value(&c.Browser.Headless, "WASM_HEADLESS")
+	str(&c.Browser.WindowSize, "WASM_WINDOW_SIZE")
+	str(&c.Browser.UserDataDir, "WASM_USER_DATA_DIR")
+
+	str(&c.Server.IndexTemplate, "WASM_INDEX_TEMPLATE")
+	str(&c.Server.WasmExecJS, "WASM_EXEC_JS")
+	str(&c.Server.TinyGoRoot, "WASM_TINYGOROOT")
+	onOff(&c.Server.ToolchainDownload, "WASM_TOOLCHAIN_DOWNLOAD")
+	list(&c.Server.Preload, "WASM_PRELOAD", filepath.SplitList)
+	mounts(&c.Server.Static, "WASM_STATIC")
+	mounts(&c.Server.Proxy, "WASM_PROXY")
+	onOff(&c.Server.ProxyLog, "WASM_PROXY_LOG")
+	list(&c.Server.SecurityProfiles, "WASM_SECURITY_PROFILE", commaList)
+	str(&c.Server.Headers, "WASM_HEADERS")
+	onOff(&c.Server.HTTPS, "WASM_HTTPS")
+	list(&c.Server.CORSAllow, "WASM_CORS_ALLOW", commaList)
+	str(&c.Server.Mocks, "WASM_MOCKS")
+	str(&c.Server.Sidecars, "WASM_SIDECARS")
+
+	str(&c.Artifacts.FSTrace, "WASM_FS_TRACE")
+	str(&c.Artifacts.HAR, "WASM_HAR")
+	onOff(&c.Artifacts.HARFS, "WASM_HAR_FS")
+
+	str(&c.Env.Forward, "WASM_ENV_FORWARD")
+	list(&c.Env.Keep, "WASM_ENV_KEEP", strings.Fields)
+	list(&c.Env.Remove, "WASM_ENV_REMOVE", strings.Fields)
+	list(&c.Env.KeepRegex, "WASM_ENV_KEEP_REGEX", strings.Fields)
+	list(&c.Env.RemoveRegex, "WASM_ENV_REMOVE_REGEX", strings.Fields)
+	str(&c.Env.Secrets, "WASM_ENV_SECRETS")
+
+	value(&c.Timeout, "WASM_TIMEOUT")
+	return errors.Join(errs...)
+}
+
+// registerFlags defines the flags which override c, with names starting
+// with prefix.
+func (c *config) registerFlags(fs *flag.FlagSet, prefix string) {
+	c.Browser.registerFlags(fs, prefix)
+
+	fs.StringVar(&c.Server.IndexTemplate, prefix+"index-template", c.Server.IndexTemplate, "HTML template of the page")
+	fs.StringVar(&c.Server.WasmExecJS, prefix+"wasm-exec-js", c.Server.WasmExecJS, "wasm_exec.js to use, instead of the one of the Go release which built the wasm file")
+	fs.StringVar(&c.Server.TinyGoRoot, prefix+"tinygo-root", c.Server.TinyGoRoot, "TinyGo installation whose wasm_exec.js runs TinyGo binaries, instead of the one tinygo env reports")
+	fs.BoolVar(&c.Server.ToolchainDownload, prefix+"toolchain-download", c.Server.ToolchainDownload, "let the go command download the toolchain which built the wasm file, for its wasm_exec.js")
+	fs.Var((*stringList)(&c.Server.Preload), prefix+"preload", "JavaScript file to import before the program runs (repeatable)")
+	fs.Var((*mountFlag)(&c.Server.Static), prefix+"static", "directory to serve, as prefix=dir (repeatable)")
+	fs.Var((*mountFlag)(&c.Server.Proxy), prefix+"proxy", "URL to forward to, as prefix=URL (repeatable)")
+	fs.BoolVar(&c.Server.ProxyLog, prefix+"proxy-log", c.Server.ProxyLog, "log proxied requests")
+	fs.Var((*stringList)(&c.Server.SecurityProfiles), prefix+"security-profile", "security profile: coi or csp (repeatable)")
+	fs.StringVar(&c.Server.Headers, prefix+"headers", c.Server.Headers, "JSON file of response headers by path prefix")
+	fs.BoolVar(&c.Server.HTTPS, prefix+"https", c.Server.HTTPS, "serve over HTTPS")
+	fs.Var((*stringList)(&c.Server.CORSAllow), prefix+"cors-allow", "origin whose responses the page may read (repeatable)")
+	fs.StringVar(&c.Server.Mocks, prefix+"mocks", c.Server.Mocks, "JSON fixtures file of network mocks")
+	fs.StringVar(&c.Server.Sidecars, prefix+"sidecars", c.Server.Sidecars, "JSON file of sidecar processes")
+
+	fs.StringVar(&c.Artifacts.FSTrace, prefix+"fs-trace", c.Artifacts.FSTrace, "file to trace the file system calls to")
+	fs.StringVar(&c.Artifacts.HAR, prefix+"har", c.Artifacts.HAR, "file to record the network traffic to")
+	fs.BoolVar(&c.Artifacts.HARFS, prefix+"har-fs", c.Artifacts.HARFS, "record the file system calls in the HAR file")
+
+	fs.StringVar(&c.Env.Forward, prefix+"env-forward", c.Env.Forward, "variables to pass to the program: all or kept")
+	fs.Var((*stringList)(&c.Env.Keep), prefix+"env-keep", "variable to pass, or prefix ending with * (repeatable)")
+	fs.Var((*stringList)(&c.Env.Remove), prefix+"env-remove", "variable not to pass, or prefix ending with * (repeatable)")
+	fs.Var((*stringList)(&c.Env.KeepRegex), prefix+"env-keep-regex", "regular expression of variables to pass (repeatable)")
+	fs.Var((*stringList)(&c.Env.RemoveRegex), prefix+"env-remove-regex", "regular expression of variables not to pass (repeatable)")
+	fs.StringVar(&c.Env.Secrets, prefix+"env-secrets", c.Env.Secrets, "secret-looking variables: redact, drop or keep")
+
+	fs.Var(&c.Timeout, prefix+"timeout", "time after which the run fails, like 5m")
+}
+
+// source tells which file c was read from.
+func (c *config) source() string {
+	if c.file == "" {
+		return "no config file"
+	}
+	return "config file: " + c.file
+}
+
+// write writes c in the format of the config file.
+func (c *config) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// mountMap converts mounts to the prefix to target map of the config.
+func mountMap(mounts []mount) map[string]string {
+	m := make(map[string]string, len(mounts))
+	for _, mnt := range mounts {
+		m[mnt.prefix] = mnt.target
+	}
+	return m
+}
+
+// mountsOf converts a prefix to target map of the config to mounts, in a
+// stable order.
+func mountsOf(m map[string]string) []mount {
+	mounts := make([]mount, 0, len(m))
+	for prefix, target := range m {
+		mounts = append(mounts, mount{prefix: cleanPrefix(prefix), target: target})
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].prefix < mounts[j].prefix })
+	return mounts
+}
+
+// mountFlag adds a prefix=target pair to a map.
+type mountFlag map[string]string
+
+func (mf *mountFlag) String() string {
+	if mf == nil {
+		return ""
+	}
+	var entries []string
+	for _, m := range mountsOf(*mf) {
+		entries = append(entries, m.prefix+"="+m.target)
+	}
+	return strings.Join(entries, ",")
+}
+
+func (mf *mountFlag) Set(s string) error {
+	mounts, err := parseMounts(s)
+	if err != nil {
+		return err
+	}
+	if *mf == nil {
+		*mf = make(map[string]string)
+	}
+	for _, m := range mounts {
+		(*mf)[m.prefix] = m.target
+	}
+	return nil
+}
+
+// duration is a time.Duration written like 5m in the config file.
+type duration time.Duration
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *duration) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearWASMEnv unsets the WASM_* variables for the duration of the test.
+func clearWASMEnv(t *testing.T) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "WASM_") {
+			t.Setenv(name, "")
+		}
+	}
+}
+
+func TestFindConfigFile(t *testing.T) {
+	root := t.TempDir()
+	pkg := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(pkg, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := findConfigFile(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A config file above the temporary directory would be found too.
+	if strings.HasPrefix(path, root) {
+		t.Errorf("found %s, expected no config file", path)
+	}
+
+	expected := filepath.Join(root, configFileName)
+	if err := os.WriteFile(expected, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, err = findConfigFile(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != expected {
+		t.Errorf("found %s, expected %s", path, expected)
+	}
+
+	closer := filepath.Join(root, "a", configFileName)
+	if err := os.WriteFile(closer, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, err = findConfigFile(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != closer {
+		t.Errorf("found %s, expected %s", path, closer)
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, configFileName)
+	err := os.WriteFile(path, []byte(`{
+		"browser": {"path": "chromium", "flags": ["--no-sandbox"], "userDataDir": "profile"},
+		"server": {
+			"preload": ["polyfill.js", "/abs/setup.js"],
+			"static": {"/assets": "testdata"},
+			"proxy": {"/api": "http://localhost:8080"},
+			"mocks": "mocks.json"
+		},
+		"artifacts": {"har": "out/run.har"},
+		"timeout": "2m"
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := config{
+		Browser: browserConfig{
+			Path:        "chromium",
+			Flags:       []string{"--no-sandbox"},
+			UserDataDir: filepath.Join(dir, "profile"),
+		},
+		Server: serverConfig{
+			Preload: []string{filepath.Join(dir, "polyfill.js"), "/abs/setup.js"},
+			Static:  map[string]string{"/assets": filepath.Join(dir, "testdata")},
+			Proxy:   map[string]string{"/api": "http://localhost:8080"},
+			Mocks:   filepath.Join(dir, "mocks.json"),
+		},
+		Artifacts: artifactsConfig{HAR: filepath.Join(dir, "out", "run.har")},
+		Timeout:   duration(2 * time.Minute),
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("got %+v, expected %+v", c, expected)
+	}
+}
+
+func TestReadConfigFile_invalid(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		content     string
+	}{
+		{"unknown field", `{"server": {"statik": {"/": "."}}}`},
+		{"invalid timeout", `{"timeout": "soon"}`},
+		{"invalid headless mode", `{"browser": {"headless": "yes"}}`},
+		{"not JSON", `timeout = 5m`},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), configFileName)
+			if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readConfigFile(path); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	clearWASMEnv(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, configFileName)
+	err := os.WriteFile(path, []byte(`{
+		"browser": {"headless": "off", "windowSize": "1024x768"},
+		"server": {"https": true, "corsAllow": ["http://localhost:9000"]},
+		"timeout": "1m"
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WASM_CONFIG", path)
+	t.Setenv("WASM_BROWSER", "/usr/bin/chromium")
+	t.Setenv("WASM_BROWSER_FLAGS", "--enable-logging --v=1")
+	t.Setenv("WASM_HEADLESS", "old")
+	t.Setenv("WASM_HTTPS", "off")
+	t.Setenv("WASM_STATIC", "/data=testdata")
+	t.Setenv("WASM_TIMEOUT", "30s")
+
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("wasmbrowsertest", flag.ContinueOnError)
+	c.registerFlags(fs, runnerFlagPrefix)
+	rest, err := gentleParse(fs, []string{
+		"-test.v", "-wbt.headless=new", "-wbt.browser-flag=--js-flags=--expose-gc",
+		"-wbt.browser-flag", "--no-sandbox", "-wbt.static=/img=images",
+		"-wbt.timeout=10s", "-test.run=TestX",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rest, []string{"-test.v", "-test.run=TestX"}) {
+		t.Errorf("unexpected test arguments %v", rest)
+	}
+
+	expected := config{
+		Browser: browserConfig{
+			Path:       "/usr/bin/chromium",
+			Flags:      []string{"--enable-logging", "--v=1", "--js-flags=--expose-gc", "--no-sandbox"},
+			Headless:   "new",
+			WindowSize: "1024x768",
+		},
+		Server: serverConfig{
+			Static:    map[string]string{"/data/": "testdata", "/img/": "images"},
+			CORSAllow: []string{"http://localhost:9000"},
+		},
+		Timeout: duration(10 * time.Second),
+		file:    path,
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("got %+v, expected %+v", c, expected)
+	}
+}
+
+func TestLoadConfig_off(t *testing.T) {
+	clearWASMEnv(t)
+	t.Setenv("WASM_CONFIG", "off")
+	t.Setenv("WASM_PROXY_LOG", "yes")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "WASM_PROXY_LOG") {
+		t.Fatalf("expected an error about WASM_PROXY_LOG, got %v", err)
+	}
+
+	t.Setenv("WASM_PROXY_LOG", "on")
+	t.Setenv("WASM_HEADLESS", "chrome")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "WASM_HEADLESS") {
+		t.Fatalf("expected an error about WASM_HEADLESS, got %v", err)
+	}
+
+	t.Setenv("WASM_HEADLESS", "")
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.file != "" || !c.Server.ProxyLog {
+		t.Errorf("unexpected config %+v", c)
+	}
+}
+
+func TestConfigWrite(t *testing.T) {
+	c := config{
+		Browser: browserConfig{Headless: "new"},
+		Server:  serverConfig{Static: map[string]string{"/": "public"}},
+		Timeout: duration(90 * time.Second),
+		file:    "/src/.wasmbrowsertest.json",
+	}
+	var buf bytes.Buffer
+	if err := c.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "browser": {
+    "headless": "new"
+  },
+  "server": {
+    "static": {
+      "/": "public"
+    }
+  },
+  "artifacts": {},
+  "env": {},
+  "timeout": "1m30s"
+}
+`
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestConfigWrite_readBack(t *testing.T) {
+	dir := t.TempDir()
+	c := config{
+		Browser: browserConfig{Headless: "off", Flags: []string{"--lang=fr"}},
+		Server:  serverConfig{Static: map[string]string{"/": filepath.Join(dir, "public")}, ProxyLog: true},
+		Env:     envConfig{Forward: "kept", Keep: []string{"HOME"}},
+		Timeout: duration(time.Minute),
+	}
+	var buf bytes.Buffer
+	if err := c.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, ".wasmbrowsertest.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, c) {
+		t.Errorf("read back %+v, expected %+v", read, c)
+	}
+}
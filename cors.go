@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// corsAllowlist are the origins, like http://localhost:8080, whose
+// responses may be read by the page whatever their CORS headers say.
+type corsAllowlist []string
+
+// parseCORSAllowlist parses a comma separated list of origins.
+func parseCORSAllowlist(s string) (corsAllowlist, error) {
+	var origins corsAllowlist
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		origin, ok := originOf(entry)
+		if !ok || strings.TrimSuffix(entry, "/") != origin {
+			return nil, fmt.Errorf("invalid CORS origin %q, expected scheme://host:port", entry)
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// originOf returns the origin of an http or https URL.
+func originOf(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.Scheme + "://" + u.Host, true
+}
+
+// allows reports whether the origin of rawURL is in the list.
+func (cl corsAllowlist) allows(rawURL string) bool {
+	origin, ok := originOf(rawURL)
+	if !ok {
+		return false
+	}
+	for _, allowed := range cl {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCORSAllowlist(t *testing.T) {
+	cl, err := parseCORSAllowlist("http://localhost:8080,https://[::1]:9443/,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cl, corsAllowlist{"http://localhost:8080", "https://[::1]:9443"}) {
+		t.Errorf("unexpected allowlist %v", cl)
+	}
+	for _, origin := range []string{"localhost:8080", "ftp://localhost", "http://localhost:8080/api", "*"} {
+		if _, err := parseCORSAllowlist(origin); err == nil {
+			t.Errorf("%q: expected an error", origin)
+		}
+	}
+	if cl.allows("http://localhost:8081/") || cl.allows("https://localhost:8080/") || !cl.allows("http://localhost:8080/x?y") {
+		t.Error("allows does not compare origins")
+	}
+}
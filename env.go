@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/agnivade/wasmbrowsertest/internal/wasmenv"
+)
+
+// envConfig decides which variables of the environment are passed to the
+// program. They end up in the page, and wasm_exec.js has little room for
+// them.
+//
+// A variable matching Keep or KeepRegex is passed. Otherwise, one matching
+// Remove or RemoveRegex is not. Otherwise, one of wasmenv.Common is
+// passed. Otherwise it is passed only if Forward is "all", with its value
+// redacted if its name looks like a secret.
+type envConfig struct {
+	// Forward is "all" to pass the variables which are not removed, which
+	// is the default, or "kept" to pass only the kept ones.
+	Forward string `json:"forward,omitempty"`
+	// Keep and Remove are names, or prefixes ending with *.
+	Keep   []string `json:"keep,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+	// KeepRegex and RemoveRegex are regular expressions matching names.
+	KeepRegex   []string `json:"keepRegex,omitempty"`
+	RemoveRegex []string `json:"removeRegex,omitempty"`
+	// Secrets is "redact" to replace the value of secret-looking
+	// variables, which is the default, "drop" to not pass them or "keep".
+	Secrets string `json:"secrets,omitempty"`
+}
+
+// secretEnvName matches names of variables which probably hold a secret.
+var secretEnvName = regexp.MustCompile(`(?i)TOKEN|SECRET|PASSWORD|PASSWD|PASSPHRASE|CREDENTIAL|PRIVATE|AUTH|SESSION|COOKIE|API_?KEY|ACCESS_?KEY|(^|_)KEY($|_)`)
+
+const redactedValue = "[redacted]"
+
+// filter returns the variables of environ, KEY=value pairs like
+// os.Environ returns, which are passed to the program.
+func (ec envConfig) filter(environ []string) (map[string]string, error) {
+	forwardAll := true
+	switch ec.Forward {
+	case "", "all":
+	case "kept":
+		forwardAll = false
+	default:
+		return nil, fmt.Errorf("invalid env forwarding %q, expected all or kept", ec.Forward)
+	}
+	switch ec.Secrets {
+	case "", "redact", "drop", "keep":
+	default:
+		return nil, fmt.Errorf("invalid env secrets handling %q, expected redact, drop or keep", ec.Secrets)
+	}
+	keep, err := envPatterns(ec.Keep, ec.KeepRegex)
+	if err != nil {
+		return nil, err
+	}
+	remove, err := envPatterns(ec.Remove, ec.RemoveRegex)
+	if err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		switch {
+		case matchAny(keep, name):
+		case matchAny(remove, name):
+			continue
+		case wasmenv.IsCommon(name):
+		case !forwardAll:
+			continue
+		case secretEnvName.MatchString(name):
+			switch ec.Secrets {
+			case "", "redact":
+				value = redactedValue
+			case "drop":
+				continue
+			}
+		}
+		env[name] = value
+	}
+	return env, nil
+}
+
+// envPatterns compiles names or prefixes ending with *, and regular
+// expressions, to patterns matching whole names.
+func envPatterns(globs, regexes []string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, g := range globs {
+		patterns = append(patterns, globPattern(g))
+	}
+	for _, r := range regexes {
+		re, err := regexp.Compile("^(?:" + r + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid env regex: %w", err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// argsWarnSize is when the runner warns about the size of the arguments and
+// the environment.
+const argsWarnSize = wasmenv.Limit - wasmenv.ArgsReserve
+
+// checkArgsSize warns when args and env are close to the size limit of
+// wasm_exec.js, with the largest variables.
+func checkArgsSize(args []string, env map[string]string, logger *log.Logger) {
+	environ := make([]string, 0, len(env))
+	for name, value := range env {
+		environ = append(environ, name+"="+value)
+	}
+	total := wasmenv.Total(args, environ)
+	if total < argsWarnSize {
+		return
+	}
+	verdict := "close to"
+	if total >= wasmenv.Limit {
+		verdict = "over"
+	}
+	logger.Printf("warning: the arguments and environment take %d bytes, %s the limit of %d bytes, the largest variables are:",
+		total, verdict, wasmenv.Limit)
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	size := func(name string) int { return wasmenv.Size(name + "=" + env[name]) }
+	sort.Slice(names, func(i, j int) bool {
+		if si, sj := size(names[i]), size(names[j]); si != sj {
+			return si > sj
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names[:min(5, len(names))] {
+		logger.Printf("  %s: %d bytes", name, size(name))
+	}
+	logger.Printf("remove variables with WASM_ENV_REMOVE, or pass only the kept ones with WASM_ENV_FORWARD=kept")
+}
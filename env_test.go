@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvFilter(t *testing.T) {
+	environ := []string{
+		"GOFLAGS=-mod=mod",
+		"GOPRIVATE=example.com",
+		"HOME=/home/gopher",
+		"LC_ALL=C",
+		"GITHUB_TOKEN=ghp_secret",
+		"GITHUB_SHA=abc123",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"SSH_KEY=secret",
+		"KEYBOARD=us",
+		"APP_MODE=test",
+		"GOOGLE_API_KEY=secret",
+		"EMPTY=",
+	}
+	for _, tc := range []struct {
+		description string
+		config      envConfig
+		expected    map[string]string
+		expectErr   bool
+	}{
+		{
+			description: "default",
+			expected: map[string]string{
+				"GOFLAGS":               "-mod=mod",
+				"GOPRIVATE":             "example.com",
+				"HOME":                  "/home/gopher",
+				"LC_ALL":                "C",
+				"GITHUB_TOKEN":          redactedValue,
+				"GITHUB_SHA":            "abc123",
+				"AWS_SECRET_ACCESS_KEY": redactedValue,
+				"SSH_KEY":               redactedValue,
+				"KEYBOARD":              "us",
+				"APP_MODE":              "test",
+				"GOOGLE_API_KEY":        redactedValue,
+				"EMPTY":                 "",
+			},
+		},
+		{
+			description: "kept only",
+			config:      envConfig{Forward: "kept", Keep: []string{"APP_*"}, KeepRegex: []string{"GITHUB_(SHA|REF)"}},
+			expected: map[string]string{
+				"GOFLAGS":    "-mod=mod",
+				"GOPRIVATE":  "example.com",
+				"HOME":       "/home/gopher",
+				"LC_ALL":     "C",
+				"GITHUB_SHA": "abc123",
+				"APP_MODE":   "test",
+			},
+		},
+		{
+			description: "removed, and secrets dropped",
+			config:      envConfig{Remove: []string{"GITHUB_*", "GOFLAGS"}, RemoveRegex: []string{".*BOARD"}, Secrets: "drop"},
+			expected: map[string]string{
+				"GOPRIVATE": "example.com",
+				"HOME":      "/home/gopher",
+				"LC_ALL":    "C",
+				"APP_MODE":  "test",
+				"EMPTY":     "",
+			},
+		},
+		{
+			description: "kept wins over removed and secrets",
+			config:      envConfig{Keep: []string{"GITHUB_TOKEN"}, Remove: []string{"GITHUB_*"}},
+			expected: map[string]string{
+				"GOFLAGS":               "-mod=mod",
+				"GOPRIVATE":             "example.com",
+				"HOME":                  "/home/gopher",
+				"LC_ALL":                "C",
+				"GITHUB_TOKEN":          "ghp_secret",
+				"AWS_SECRET_ACCESS_KEY": redactedValue,
+				"SSH_KEY":               redactedValue,
+				"KEYBOARD":              "us",
+				"APP_MODE":              "test",
+				"GOOGLE_API_KEY":        redactedValue,
+				"EMPTY":                 "",
+			},
+		},
+		{
+			description: "invalid forwarding",
+			config:      envConfig{Forward: "some"},
+			expectErr:   true,
+		},
+		{
+			description: "invalid secrets handling",
+			config:      envConfig{Secrets: "hide"},
+			expectErr:   true,
+		},
+		{
+			description: "invalid regex",
+			config:      envConfig{RemoveRegex: []string{"GITHUB_("}},
+			expectErr:   true,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			env, err := tc.config.filter(environ)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(env, tc.expected) {
+				t.Errorf("got %v, expected %v", env, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCheckArgsSize(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	env := map[string]string{"HOME": "/root"}
+	checkArgsSize([]string{"a.wasm"}, env, logger)
+	if logs.Len() != 0 {
+		t.Errorf("unexpected warning %q", logs.String())
+	}
+
+	env["BIG"] = strings.Repeat("x", 5000)
+	env["LARGE"] = strings.Repeat("x", 3000)
+	checkArgsSize([]string{"a.wasm"}, env, logger)
+	expected := `warning: the arguments and environment take 8088 bytes, close to the limit of 8192 bytes, the largest variables are:
+  BIG: 5016 bytes
+  LARGE: 3016 bytes
+  HOME: 24 bytes
+remove variables with WASM_ENV_REMOVE, or pass only the kept ones with WASM_ENV_FORWARD=kept
+`
+	if logs.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", logs.String(), expected)
+	}
+
+	logs.Reset()
+	env["LARGE"] = strings.Repeat("x", 4000)
+	checkArgsSize([]string{"a.wasm"}, env, logger)
+	if !strings.Contains(logs.String(), "over the limit") {
+		t.Errorf("expected a warning over the limit, got %q", logs.String())
+	}
+}
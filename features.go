@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+const wasmCodeSection = 10
+
+// wasmFeature is a WebAssembly feature beyond the first version, which Go
+// uses as GOWASM says.
+type wasmFeature struct {
+	name   string
+	gowasm string
+	// probe is the smallest module using the feature.
+	probe []byte
+}
+
+var (
+	// signExt is the sign-extension operators.
+	signExt = &wasmFeature{
+		name:   "sign-ext",
+		gowasm: "signext",
+		// (func (result i32) i32.const 0 i32.extend8_s)
+		probe: []byte{
+			0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+			0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f,
+			0x03, 0x02, 0x01, 0x00,
+			0x0a, 0x07, 0x01, 0x05, 0x00, 0x41, 0x00, 0xc0, 0x0b,
+		},
+	}
+	// satConv is the non-trapping float-to-int conversions.
+	satConv = &wasmFeature{
+		name:   "sat-conv",
+		gowasm: "satconv",
+		// (func (result i32) f32.const 0 i32.trunc_sat_f32_s)
+		probe: []byte{
+			0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+			0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f,
+			0x03, 0x02, 0x01, 0x00,
+			0x0a, 0x0b, 0x01, 0x09, 0x00, 0x43, 0x00, 0x00, 0x00, 0x00, 0xfc, 0x00, 0x0b,
+		},
+	}
+)
+
+// features returns the features of signExt and satConv which the code of
+// the module uses. A function with an instruction it does not know is
+// skipped, the runner only needs to know what Go emits.
+func (m *wasmModule) features() ([]*wasmFeature, error) {
+	used := make(map[*wasmFeature]bool)
+	for _, s := range m.sections {
+		if s.id != wasmCodeSection {
+			continue
+		}
+		r := &wasmReader{buf: s.data}
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			body := r.bytes(int(r.u32()))
+			if r.err != nil {
+				break
+			}
+			scanFunction(body, used)
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("invalid code section: %w", r.err)
+		}
+	}
+	var features []*wasmFeature
+	for _, f := range []*wasmFeature{signExt, satConv} {
+		if used[f] {
+			features = append(features, f)
+		}
+	}
+	return features, nil
+}
+
+// scanFunction records the features a function body uses.
+func scanFunction(body []byte, used map[*wasmFeature]bool) {
+	r := &wasmReader{buf: body}
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		r.u32()  // count
+		r.byte() // type
+	}
+	found := make(map[*wasmFeature]bool)
+	for r.off < len(r.buf) && r.err == nil {
+		op := r.byte()
+		switch {
+		case op <= 0x01, op == 0x05, op == 0x0b, op == 0x0f, op == 0x1a, op == 0x1b,
+			op >= 0x45 && op <= 0xbf, op == 0xd1:
+			// No immediates.
+		case op >= 0xc0 && op <= 0xc4:
+			found[signExt] = true
+		case op >= 0x02 && op <= 0x04:
+			r.leb() // block type
+		case op == 0x0c, op == 0x0d, op == 0x10, op >= 0x20 && op <= 0x26, op == 0x12, op == 0xd2:
+			r.leb()
+		case op == 0x0e:
+			for n := r.u32(); n > 0 && r.err == nil; n-- {
+				r.leb()
+			}
+			r.leb()
+		case op == 0x11, op == 0x13:
+			r.leb()
+			r.leb()
+		case op == 0x1c:
+			r.bytes(int(r.u32()))
+		case op >= 0x28 && op <= 0x3e:
+			r.leb() // align
+			r.leb() // offset
+		case op == 0x3f, op == 0x40, op == 0xd0:
+			r.byte()
+		case op == 0x41, op == 0x42:
+			r.leb()
+		case op == 0x43:
+			r.bytes(4)
+		case op == 0x44:
+			r.bytes(8)
+		case op == 0xfc:
+			switch sub := r.u32(); {
+			case sub <= 0x07:
+				found[satConv] = true
+			case sub == 0x09, sub == 0x0b, sub == 0x0d, sub >= 0x0f && sub <= 0x11:
+				r.leb()
+			case sub == 0x08:
+				r.leb()
+				r.byte()
+			case sub == 0x0a, sub == 0x0c, sub == 0x0e:
+				r.leb()
+				r.leb()
+			default:
+				return
+			}
+		default:
+			return
+		}
+	}
+	if r.err != nil {
+		return
+	}
+	for f := range found {
+		used[f] = true
+	}
+}
+
+// checkBrowserFeatures fails if the browser does not support the
+// features, which wasmFile uses.
+func checkBrowserFeatures(features []*wasmFeature, wasmFile string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		var probes []string
+		for _, f := range features {
+			bytes := make([]string, len(f.probe))
+			for i, b := range f.probe {
+				bytes[i] = strconv.Itoa(int(b))
+			}
+			probes = append(probes, "WebAssembly.validate(new Uint8Array(["+strings.Join(bytes, ",")+"]))")
+		}
+		var supported []bool
+		if err := chromedp.Evaluate("["+strings.Join(probes, ", ")+"]", &supported).Do(ctx); err != nil {
+			return fmt.Errorf("error probing the WebAssembly features of the browser: %w", err)
+		}
+		var missing, gowasm []string
+		for i, f := range features {
+			if i < len(supported) && !supported[i] {
+				missing = append(missing, f.name)
+				gowasm = append(gowasm, f.gowasm)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("the browser does not support the WebAssembly %s features which %s uses (GOWASM=%s). Update the browser",
+				strings.Join(missing, " and "), wasmFile, strings.Join(gowasm, ","))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// wasmTestCode returns a module with a function of type 0 per body, the
+// bodies are given without their size.
+func wasmTestCode(bodies ...[]byte) []byte {
+	types := []byte{1, 1, 0x60, 0, 1, 0x7f}
+	funcs := appendU32([]byte{3}, uint32(len(bodies)))
+	code := appendU32([]byte{wasmCodeSection}, uint32(len(bodies)))
+	for _, body := range bodies {
+		funcs = append(funcs, 0)
+		code = appendU32(code, uint32(len(body)))
+		code = append(code, body...)
+	}
+	return wasmTestModule(types, funcs, code)
+}
+
+func TestWasmFeatures(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		module      []byte
+		expected    []*wasmFeature
+	}{
+		{
+			description: "sign-ext probe",
+			module:      signExt.probe,
+			expected:    []*wasmFeature{signExt},
+		},
+		{
+			description: "sat-conv probe",
+			module:      satConv.probe,
+			expected:    []*wasmFeature{satConv},
+		},
+		{
+			description: "no code",
+			module:      wasmTestModule(wasmTestProducers("go1.24.3")),
+		},
+		{
+			description: "feature opcodes in immediates",
+			module: wasmTestCode(
+				// One i32 local, i32.const 64, i32.load offset=252, f32.const with 0xfc bytes.
+				[]byte{1, 1, 0x7f, 0x41, 0xc0, 0x00, 0x28, 0x02, 0xfc, 0x01, 0x43, 0xfc, 0x00, 0xc4, 0xc0, 0x1a, 0x1a, 0x0b},
+			),
+		},
+		{
+			description: "both, in blocks and branches",
+			module: wasmTestCode(
+				// block, br_table, then i32.const 0, i32.extend16_s.
+				[]byte{0, 0x02, 0x40, 0x41, 0x00, 0x0e, 0x01, 0x00, 0x00, 0x0b, 0x41, 0x00, 0xc1, 0x0b},
+				// f64.const 0, i32.trunc_sat_f64_u.
+				[]byte{0, 0x44, 0, 0, 0, 0, 0, 0, 0, 0, 0xfc, 0x03, 0x0b},
+			),
+			expected: []*wasmFeature{signExt, satConv},
+		},
+		{
+			description: "unknown instruction",
+			module: wasmTestCode(
+				// A SIMD instruction, then what would be i32.extend8_s.
+				[]byte{0, 0xfd, 0x0c, 0xc0, 0x0b},
+			),
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			m, err := parseWasmModule(tc.module)
+			if err != nil {
+				t.Fatal(err)
+			}
+			features, err := m.features()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(features, tc.expected) {
+				t.Errorf("got %v, expected %v", features, tc.expected)
+			}
+		})
+	}
+}
+
+func TestWasmFeatures_invalid(t *testing.T) {
+	m, err := parseWasmModule(wasmTestModule([]byte{wasmCodeSection, 2, 5, 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.features(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
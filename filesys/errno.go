@@ -0,0 +1,77 @@
+package filesys
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// errnoNames maps host errnos to the codes understood by Go's js/wasm
+// syscall package. Every name here must be a key of errnoByCode in
+// https://github.com/golang/go/blob/master/src/syscall/tables_js.go,
+// otherwise the guest panics while mapping the error.
+var errnoNames = map[syscall.Errno]string{
+	syscall.EPERM:        "EPERM",
+	syscall.ENOENT:       "ENOENT",
+	syscall.EINTR:        "EINTR",
+	syscall.EIO:          "EIO",
+	syscall.ENXIO:        "ENXIO",
+	syscall.E2BIG:        "E2BIG",
+	syscall.EBADF:        "EBADF",
+	syscall.EAGAIN:       "EAGAIN",
+	syscall.ENOMEM:       "ENOMEM",
+	syscall.EACCES:       "EACCES",
+	syscall.EFAULT:       "EFAULT",
+	syscall.EBUSY:        "EBUSY",
+	syscall.EEXIST:       "EEXIST",
+	syscall.EXDEV:        "EXDEV",
+	syscall.ENODEV:       "ENODEV",
+	syscall.ENOTDIR:      "ENOTDIR",
+	syscall.EISDIR:       "EISDIR",
+	syscall.EINVAL:       "EINVAL",
+	syscall.ENFILE:       "ENFILE",
+	syscall.EMFILE:       "EMFILE",
+	syscall.ENOTTY:       "ENOTTY",
+	syscall.EFBIG:        "EFBIG",
+	syscall.ENOSPC:       "ENOSPC",
+	syscall.ESPIPE:       "ESPIPE",
+	syscall.EROFS:        "EROFS",
+	syscall.EMLINK:       "EMLINK",
+	syscall.EPIPE:        "EPIPE",
+	syscall.ENAMETOOLONG: "ENAMETOOLONG",
+	syscall.ENOSYS:       "ENOSYS",
+	syscall.EDQUOT:       "EDQUOT",
+	syscall.ENOTEMPTY:    "ENOTEMPTY",
+	syscall.ELOOP:        "ELOOP",
+	syscall.EOVERFLOW:    "EOVERFLOW",
+	syscall.EILSEQ:       "EILSEQ",
+	syscall.ENOTSUP:      "ENOTSUP",
+	syscall.ECANCELED:    "ECANCELED",
+	syscall.ETIMEDOUT:    "ETIMEDOUT",
+	syscall.ESTALE:       "ESTALE",
+}
+
+// errnoOf returns the errno name and message to report to the guest for err.
+// Errors that carry no recognizable errno are reported as ENOSYS.
+func errnoOf(err error) (code string, msg string) {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if name, ok := hostErrnoNames[errno]; ok {
+			return name, errno.Error()
+		}
+		if name, ok := errnoNames[errno]; ok {
+			return name, errno.Error()
+		}
+	}
+	// The other error numbers of Windows only match the portable sentinel
+	// errors.
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "ENOENT", syscall.ENOENT.Error()
+	case errors.Is(err, fs.ErrExist):
+		return "EEXIST", syscall.EEXIST.Error()
+	case errors.Is(err, fs.ErrPermission):
+		return "EACCES", syscall.EACCES.Error()
+	}
+	return "ENOSYS", syscall.ENOSYS.Error()
+}
@@ -0,0 +1,97 @@
+package filesys
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestErrnoCodes checks the errno each api reports for a failing call, on
+// every host.
+func TestErrnoCodes(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		api     string
+		expect  string
+		payload func(h *helperApi) any
+	}{
+		{
+			name: "open missing file", api: "open", expect: "ENOENT",
+			payload: func(h *helperApi) any {
+				return &Open{Path: h.tempPath("missing.txt")}
+			},
+		},
+		{
+			name: "open file as directory", api: "open", expect: "ENOTDIR",
+			payload: func(h *helperApi) any {
+				return &Open{Path: h.createFile("file.txt", "data"), Flags: guestDIRECTORY}
+			},
+		},
+		{
+			name: "stat missing file", api: "stat", expect: "ENOENT",
+			payload: func(h *helperApi) any {
+				return &Stat{Path: h.tempPath("missing.txt")}
+			},
+		},
+		{
+			name: "mkdir existing directory", api: "mkdir", expect: "EEXIST",
+			payload: func(h *helperApi) any {
+				return &Mkdir{Path: h.tmpDir, Perm: 0755}
+			},
+		},
+		{
+			name: "mkdir missing parent", api: "mkdir", expect: "ENOENT",
+			payload: func(h *helperApi) any {
+				return &Mkdir{Path: h.tempPath("missing/child"), Perm: 0755}
+			},
+		},
+		{
+			name: "rmdir non-empty directory", api: "rmdir", expect: "ENOTEMPTY",
+			payload: func(h *helperApi) any {
+				h.createFile("file.txt", "data")
+				return &Rmdir{Path: h.tmpDir}
+			},
+		},
+		{
+			name: "unlink missing file", api: "unlink", expect: "ENOENT",
+			payload: func(h *helperApi) any {
+				return &Unlink{Path: h.tempPath("missing.txt")}
+			},
+		},
+		{
+			name: "close bad descriptor", api: "close", expect: "EBADF",
+			payload: func(h *helperApi) any {
+				return &Close{Fd: 1 << 30}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			help := Helper(t)
+			response := &ErrorCode{}
+			help.httpBad(help.req(tc.api, tc.payload(help), response))
+			help.errorCode(response.Code, tc.expect)
+		})
+	}
+}
+
+func TestErrnoOf(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code string
+	}{
+		{syscall.ENOENT, "ENOENT"},
+		{&fs.PathError{Op: "open", Path: "x", Err: syscall.EEXIST}, "EEXIST"},
+		{&os.LinkError{Op: "rename", Old: "x", New: "y", Err: syscall.EXDEV}, "EXDEV"},
+		{fs.ErrNotExist, "ENOENT"},
+		{fs.ErrExist, "EEXIST"},
+		{fs.ErrPermission, "EACCES"},
+		{errors.New("something else"), "ENOSYS"},
+	} {
+		code, _ := errnoOf(tc.err)
+		if code != tc.code {
+			t.Errorf("errnoOf(%v) = %q, expected %q", tc.err, code, tc.code)
+		}
+	}
+}
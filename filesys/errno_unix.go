@@ -0,0 +1,12 @@
+//go:build darwin || linux
+
+package filesys
+
+import "syscall"
+
+// hostErrnoNames maps the errors of the host that have no errno of their
+// own. Unix hosts only report errnos.
+var hostErrnoNames = map[syscall.Errno]string{}
+
+// errNotDir is the error opening a file as a directory fails with.
+const errNotDir = syscall.ENOTDIR
@@ -0,0 +1,259 @@
+//go:build darwin || linux
+
+package filesys
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestErrnoConformance checks that each api reports the same errno for a
+// failing call as the equivalent native system call does.
+func TestErrnoConformance(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		api    string
+		expect string
+		is     error
+		setup  func(h *helperApi) (payload any, native func() error)
+	}{
+		{
+			name: "open missing file", api: "open", expect: "ENOENT", is: fs.ErrNotExist,
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.tempPath("missing.txt")
+				return &Open{Path: path, Flags: 0}, func() error {
+					return sysOpenClose(path, os.O_RDONLY, 0)
+				}
+			},
+		},
+		{
+			name: "open exclusive existing file", api: "open", expect: "EEXIST", is: fs.ErrExist,
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.createFile("exists.txt", "data")
+				flags := os.O_RDWR | os.O_CREATE | os.O_EXCL
+				return &Open{Path: path, Flags: guestRDWR | guestCREAT | guestEXCL, Mode: 0644}, func() error {
+					return sysOpenClose(path, flags, 0644)
+				}
+			},
+		},
+		{
+			name: "open directory for writing", api: "open", expect: "EISDIR",
+			setup: func(h *helperApi) (any, func() error) {
+				return &Open{Path: h.tmpDir, Flags: guestWRONLY}, func() error {
+					return sysOpenClose(h.tmpDir, os.O_WRONLY, 0)
+				}
+			},
+		},
+		{
+			name: "open below a file", api: "open", expect: "ENOTDIR",
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.createFile("file.txt", "data") + "/child"
+				return &Open{Path: path, Flags: 0}, func() error {
+					return sysOpenClose(path, os.O_RDONLY, 0)
+				}
+			},
+		},
+		{
+			name: "create in read-only directory", api: "open", expect: "EACCES", is: fs.ErrPermission,
+			setup: func(h *helperApi) (any, func() error) {
+				if os.Geteuid() == 0 {
+					h.t.Skip("permissions are not enforced for root")
+				}
+				dir := h.tempPath("readonly")
+				h.nilErr(os.Mkdir(dir, 0500))
+				path := dir + "/new.txt"
+				flags := os.O_RDWR | os.O_CREATE
+				return &Open{Path: path, Flags: guestRDWR | guestCREAT, Mode: 0644}, func() error {
+					return sysOpenClose(path, flags, 0644)
+				}
+			},
+		},
+		{
+			name: "open file as directory", api: "open", expect: "ENOTDIR",
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.createFile("file.txt", "data")
+				return &Open{Path: path, Flags: guestDIRECTORY}, func() error {
+					return sysOpenClose(path, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+				}
+			},
+		},
+		{
+			name: "stat missing file", api: "stat", expect: "ENOENT", is: fs.ErrNotExist,
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.tempPath("missing.txt")
+				return &Stat{Path: path}, func() error {
+					return syscall.Stat(path, &syscall.Stat_t{})
+				}
+			},
+		},
+		{
+			name: "lstat below a file", api: "lstat", expect: "ENOTDIR",
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.createFile("file.txt", "data") + "/child"
+				return &Lstat{Path: path}, func() error {
+					return syscall.Lstat(path, &syscall.Stat_t{})
+				}
+			},
+		},
+		{
+			name: "mkdir existing directory", api: "mkdir", expect: "EEXIST", is: fs.ErrExist,
+			setup: func(h *helperApi) (any, func() error) {
+				return &Mkdir{Path: h.tmpDir, Perm: 0755}, func() error {
+					return syscall.Mkdir(h.tmpDir, 0755)
+				}
+			},
+		},
+		{
+			name: "mkdir missing parent", api: "mkdir", expect: "ENOENT", is: fs.ErrNotExist,
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.tempPath("missing/child")
+				return &Mkdir{Path: path, Perm: 0755}, func() error {
+					return syscall.Mkdir(path, 0755)
+				}
+			},
+		},
+		{
+			name: "rmdir non-empty directory", api: "rmdir", expect: "ENOTEMPTY",
+			setup: func(h *helperApi) (any, func() error) {
+				h.createFile("file.txt", "data")
+				return &Rmdir{Path: h.tmpDir}, func() error {
+					return syscall.Rmdir(h.tmpDir)
+				}
+			},
+		},
+		{
+			name: "rmdir file", api: "rmdir", expect: "ENOTDIR",
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.createFile("file.txt", "data")
+				return &Rmdir{Path: path}, func() error {
+					return syscall.Rmdir(path)
+				}
+			},
+		},
+		{
+			name: "unlink missing file", api: "unlink", expect: "ENOENT", is: fs.ErrNotExist,
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.tempPath("missing.txt")
+				return &Unlink{Path: path}, func() error {
+					return syscall.Unlink(path)
+				}
+			},
+		},
+		{
+			// linux reports EISDIR, darwin reports EPERM.
+			name: "unlink directory", api: "unlink",
+			setup: func(h *helperApi) (any, func() error) {
+				return &Unlink{Path: h.tmpDir}, func() error {
+					return syscall.Unlink(h.tmpDir)
+				}
+			},
+		},
+		{
+			name: "rename missing file", api: "rename", expect: "ENOENT", is: fs.ErrNotExist,
+			setup: func(h *helperApi) (any, func() error) {
+				from, to := h.tempPath("missing.txt"), h.tempPath("to.txt")
+				return &Rename{From: from, To: to}, func() error {
+					return syscall.Rename(from, to)
+				}
+			},
+		},
+		{
+			name: "rename directory over non-empty directory", api: "rename",
+			setup: func(h *helperApi) (any, func() error) {
+				from, to := h.tempPath("from"), h.tempPath("to")
+				h.nilErr(os.Mkdir(from, 0755)).nilErr(os.Mkdir(to, 0755))
+				h.createFile("to/file.txt", "data")
+				return &Rename{From: from, To: to}, func() error {
+					return syscall.Rename(from, to)
+				}
+			},
+		},
+		{
+			name: "readdir file", api: "readdir", expect: "ENOTDIR",
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.createFile("file.txt", "data")
+				return &Readdir{Path: path}, func() error {
+					_, err := os.ReadDir(path)
+					return err
+				}
+			},
+		},
+		{
+			name: "write to read-only descriptor", api: "write", expect: "EBADF",
+			setup: func(h *helperApi) (any, func() error) {
+				path := h.createFile("file.txt", "data")
+				fd, closeFd := h.sysOpen(path)
+				h.t.Cleanup(closeFd)
+				return h.raw(map[string]any{"fd": h.open(path, 0)}, "x"), func() error {
+					_, err := syscall.Write(fd.(int), []byte("x"))
+					return err
+				}
+			},
+		},
+		{
+			name: "read directory", api: "read", expect: "EISDIR",
+			setup: func(h *helperApi) (any, func() error) {
+				fd, closeFd := h.sysOpen(h.tmpDir)
+				h.t.Cleanup(closeFd)
+				return h.raw(map[string]any{"fd": h.open(h.tmpDir, 0), "length": 1}, ""), func() error {
+					_, err := syscall.Read(fd.(int), make([]byte, 1))
+					return err
+				}
+			},
+		},
+		{
+			name: "close bad descriptor", api: "close", expect: "EBADF",
+			setup: func(h *helperApi) (any, func() error) {
+				return &Close{Fd: 1 << 30}, func() error {
+					return syscall.Close(1 << 30)
+				}
+			},
+		},
+		{
+			name: "fstat bad descriptor", api: "fstat", expect: "EBADF",
+			setup: func(h *helperApi) (any, func() error) {
+				return &Fstat{Fd: 1 << 30}, func() error {
+					return syscall.Fstat(1<<30, &syscall.Stat_t{})
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			help := Helper(t)
+			payload, native := tc.setup(help)
+			nativeErr := native()
+			help.true(nativeErr != nil, "native call did not fail")
+			nativeCode, nativeMsg := errnoOf(nativeErr)
+			if tc.expect != "" {
+				help.errorCode(nativeCode, tc.expect)
+			}
+			if tc.is != nil {
+				help.true(errors.Is(nativeErr, tc.is), "native error does not match "+tc.is.Error())
+			}
+
+			response := &ErrorCode{}
+			help.httpBad(help.req(tc.api, payload, response))
+			help.errorCode(response.Code, nativeCode)
+			help.true(response.Error == nativeMsg, "incorrect error message "+response.Error)
+		})
+	}
+}
+
+// open opens path through the handler and returns the guest descriptor.
+func (h *helperApi) open(path string, flags int) int {
+	h.t.Helper()
+	m := h.newMap()
+	h.httpOk(h.req("open", &Open{Path: path, Flags: flags}, &m))
+	return int(m["fd"].(float64))
+}
+
+func sysOpenClose(path string, flags int, mode uint32) error {
+	fd, err := syscall.Open(path, flags, mode)
+	if err != nil {
+		return err
+	}
+	return syscall.Close(fd)
+}
@@ -0,0 +1,18 @@
+package filesys
+
+import "syscall"
+
+// hostErrnoNames maps the Windows errors that have no errno of their own.
+// They are looked up before errnoNames, because syscall.ENOTDIR is
+// ERROR_PATH_NOT_FOUND, which POSIX reports as ENOENT.
+var hostErrnoNames = map[syscall.Errno]string{
+	syscall.ERROR_PATH_NOT_FOUND: "ENOENT",
+	syscall.Errno(6):             "EBADF",     // ERROR_INVALID_HANDLE
+	syscall.Errno(87):            "EINVAL",    // ERROR_INVALID_PARAMETER
+	syscall.ERROR_DIR_NOT_EMPTY:  "ENOTEMPTY", // os.IsExist does not cover it
+	errNotDir:                    "ENOTDIR",
+}
+
+// errNotDir is ERROR_DIRECTORY, the error opening a file as a directory
+// fails with.
+const errNotDir = syscall.Errno(267)
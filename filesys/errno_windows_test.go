@@ -0,0 +1,26 @@
+package filesys
+
+import (
+	"io/fs"
+	"syscall"
+	"testing"
+)
+
+func TestErrnoOf_windows(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code string
+	}{
+		{&fs.PathError{Op: "mkdir", Path: "x", Err: syscall.ERROR_PATH_NOT_FOUND}, "ENOENT"},
+		{&fs.PathError{Op: "open", Path: "x", Err: syscall.Errno(267)}, "ENOTDIR"},
+		{&fs.PathError{Op: "read", Path: "x", Err: syscall.Errno(6)}, "EBADF"},
+		{&fs.PathError{Op: "seek", Path: "x", Err: syscall.Errno(87)}, "EINVAL"},
+		{&fs.PathError{Op: "remove", Path: "x", Err: syscall.ERROR_DIR_NOT_EMPTY}, "ENOTEMPTY"},
+		{syscall.EISDIR, "EISDIR"},
+	} {
+		code, _ := errnoOf(tc.err)
+		if code != tc.code {
+			t.Errorf("errnoOf(%v) = %q, expected %q", tc.err, code, tc.code)
+		}
+	}
+}
@@ -1,7 +0,0 @@
-//go:build darwin || linux
-
-package filesys
-
-func FdType(fd int) int {
-	return fd
-}
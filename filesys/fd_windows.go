@@ -1,7 +0,0 @@
-package filesys
-
-import "syscall"
-
-func FdType(fd int) syscall.Handle {
-	return syscall.Handle(fd)
-}
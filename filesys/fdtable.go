@@ -0,0 +1,102 @@
+package filesys
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// firstFd is the first descriptor handed out to the guest. 0, 1 and 2 are
+// the standard streams, which the page writes itself.
+const firstFd = 3
+
+// isStdStream reports whether fd is a standard stream. The guest still
+// stats and reads them through the handler.
+func isStdStream(fd int) bool {
+	return fd >= 0 && fd < firstFd
+}
+
+// stdStreamStat is the stat of a standard stream, a character device like
+// a terminal.
+func stdStreamStat() map[string]any {
+	now := time.Now().UnixMilli()
+	return map[string]any{
+		"dev": 0, "ino": 0, "mode": 0o20000 | 0o620, // S_IFCHR
+		"nlink": 1, "uid": 0, "gid": 0,
+		"rdev": 0, "size": 0, "blksize": 0,
+		"blocks": 0, "atimeMs": now,
+		"mtimeMs": now, "ctimeMs": now,
+	}
+}
+
+// addFile registers f in the session's descriptor table and returns the
+// descriptor the guest refers to it by. Descriptors are not reused, so a
+// stale descriptor can never reach a file opened later. appending is set if
+// f was opened with O_APPEND.
+func (fa *Handler) addFile(f *os.File, appending bool) int {
+	fa.filesMu.Lock()
+	defer fa.filesMu.Unlock()
+	fd := fa.nextFd
+	fa.nextFd++
+	fa.files[fd] = f
+	if appending {
+		fa.appending[fd] = true
+	}
+	return fd
+}
+
+// isAppending reports whether fd was opened with O_APPEND.
+func (fa *Handler) isAppending(fd int) bool {
+	fa.filesMu.Lock()
+	defer fa.filesMu.Unlock()
+	return fa.appending[fd]
+}
+
+// file returns the file of the guest descriptor fd. Descriptors which were
+// not handed out by this session are rejected with EBADF.
+func (fa *Handler) file(fd int) (*os.File, error) {
+	fa.filesMu.Lock()
+	defer fa.filesMu.Unlock()
+	f, ok := fa.files[fd]
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	return f, nil
+}
+
+// removeFile unregisters fd and returns its file, or EBADF.
+func (fa *Handler) removeFile(fd int) (*os.File, error) {
+	fa.filesMu.Lock()
+	defer fa.filesMu.Unlock()
+	f, ok := fa.files[fd]
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	delete(fa.files, fd)
+	delete(fa.appending, fd)
+	return f, nil
+}
+
+// CloseFiles closes every file the guest left open at the end of the
+// session, and returns a description of each of them, ordered by descriptor.
+func (fa *Handler) CloseFiles() (leaked []string) {
+	fa.filesMu.Lock()
+	defer fa.filesMu.Unlock()
+	fds := make([]int, 0, len(fa.files))
+	for fd := range fa.files {
+		fds = append(fds, fd)
+	}
+	sort.Ints(fds)
+	for _, fd := range fds {
+		f := fa.files[fd]
+		leaked = append(leaked, fmt.Sprintf("fd %d: %s", fd, f.Name()))
+		if err := f.Close(); err != nil {
+			fa.logger.Printf("Error closing %s: %v", f.Name(), err)
+		}
+		delete(fa.files, fd)
+		delete(fa.appending, fd)
+	}
+	return leaked
+}
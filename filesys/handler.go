@@ -1,32 +1,67 @@
 package filesys
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
-// Handler translates json payload data to and from system calls like syscall.Stat
+// Handler translates request payloads to and from system calls like syscall.Stat
+//
+// A Handler serves a single session. The descriptors it hands out to the guest
+// index its own table of open files, never the host's descriptors.
 type Handler struct {
 	debug         bool
 	securityToken string
 	logger        *log.Logger
+	tracer        *Tracer
+
+	filesMu sync.Mutex
+	files   map[int]*os.File
+	nextFd  int
+	// appending are the descriptors opened with O_APPEND.
+	appending map[int]bool
+
+	// cwd is the guest's working directory, which relative paths are
+	// resolved against. It starts out as the working directory of the runner.
+	cwdMu sync.Mutex
+	cwd   string
 }
 
 func NewHandler(securityToken string, logger *log.Logger) *Handler {
+	cwd, err := os.Getwd()
+	if err != nil {
+		logger.Printf("Error getting working directory: %v", err)
+	}
 	return &Handler{
 		debug:         false,
 		securityToken: securityToken,
 		logger:        logger,
+		files:         make(map[int]*os.File),
+		appending:     make(map[int]bool),
+		nextFd:        firstFd,
+		cwd:           cwd,
 	}
 }
 
+// Cwd returns the guest's current working directory.
+func (fa *Handler) Cwd() string {
+	fa.cwdMu.Lock()
+	defer fa.cwdMu.Unlock()
+	return fa.cwd
+}
+
 func (fa *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("WBT-Token") != fa.securityToken {
 		fa.doError("not implemented", "ENOSYS", w, errors.New("missing WBT-token"))
@@ -57,6 +92,8 @@ func (fa *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fa.handle(&Unlink{}, w, r)
 	case "/fs/rmdir":
 		fa.handle(&Rmdir{}, w, r)
+	case "/fs/chdir":
+		fa.handle(&Chdir{}, w, r)
 	default:
 		fa.doError("not implemented", "ENOSYS", w,
 			fmt.Errorf("unsupported api path %q", r.URL.Path))
@@ -67,16 +104,43 @@ type Responder interface {
 	WriteResponse(fa *Handler, w http.ResponseWriter)
 }
 
+// binaryRequest is implemented by requests which carry raw data instead of
+// a json payload, so that file contents are not base64 encoded.
+type binaryRequest interface {
+	decode(r *http.Request) error
+}
+
 func (fa *Handler) handle(responder Responder, w http.ResponseWriter, r *http.Request) {
-	if err := json.NewDecoder(r.Body).Decode(responder); err != nil {
-		fa.logger.Printf("ERROR handle : %v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
+	var err error
+	if br, ok := responder.(binaryRequest); ok {
+		err = br.decode(r)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(responder)
+	}
+	if err != nil {
+		fa.doError(syscall.EINVAL.Error(), "EINVAL", w, fmt.Errorf("handle %s: %w", r.URL.Path, err))
 		return
 	}
 	if fa.debug {
 		fa.logger.Printf("handle %s %+v\n", r.URL.Path, responder)
 	}
-	responder.WriteResponse(fa, w)
+	if fa.tracer == nil {
+		responder.WriteResponse(fa, w)
+		return
+	}
+
+	// The arguments are described before the call, which may close
+	// a descriptor or change the working directory.
+	var args string
+	if t, ok := responder.(traced); ok {
+		args = t.traceArgs(fa)
+	}
+	tw := &traceResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	responder.WriteResponse(fa, tw)
+	duration := time.Since(start)
+	result, failed := tw.result()
+	fa.tracer.record(strings.TrimPrefix(r.URL.Path, "/fs/"), args, start, duration, result, failed)
 }
 
 type ErrorCode struct {
@@ -120,8 +184,47 @@ func (fa *Handler) okResponse(data any, w http.ResponseWriter) {
 	}
 }
 
-func fixPath(path string) string {
-	return strings.TrimPrefix(path, "/fs/")
+// okBinary responds with data as the raw response body.
+func (fa *Handler) okBinary(data []byte, w http.ResponseWriter) {
+	if fa.debug {
+		fa.logger.Printf("okBinary %d bytes\n", len(data))
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		fa.logger.Printf("Error writing data: %v", err)
+	}
+}
+
+// optionalInt64 parses the query parameter name, which may be absent.
+func optionalInt64(q url.Values, name string) (*int64, error) {
+	if !q.Has(name) {
+		return nil, nil
+	}
+	v, err := strconv.ParseInt(q.Get(name), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func formatPosition(position *int64) string {
+	if position == nil {
+		return "<nil>"
+	}
+	return strconv.FormatInt(*position, 10)
+}
+
+// resolvePath turns a path sent by the guest into a host path. Relative paths
+// are resolved against the guest's working directory.
+func (fa *Handler) resolvePath(path string) string {
+	path = strings.TrimPrefix(path, "/fs/")
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(fa.Cwd(), path)
 }
 
 type Stat struct {
@@ -134,12 +237,55 @@ type Open struct {
 	Mode  uint32 `json:"mode"`
 }
 
+// The open flags of the guest, as the page defines them in fs.constants.
+// They are the Linux values, whatever the host is.
+const (
+	guestWRONLY    = 0o1
+	guestRDWR      = 0o2
+	guestCREAT     = 0o100
+	guestEXCL      = 0o200
+	guestTRUNC     = 0o1000
+	guestAPPEND    = 0o2000
+	guestDIRECTORY = 0o20000
+)
+
+// hostFlags translates the open flags of the guest to those of the host.
+// O_DIRECTORY has no portable equivalent, Open checks it itself.
+func hostFlags(flags int) int {
+	host := os.O_RDONLY
+	for guest, h := range map[int]int{
+		guestWRONLY: os.O_WRONLY,
+		guestRDWR:   os.O_RDWR,
+		guestCREAT:  os.O_CREATE,
+		guestEXCL:   os.O_EXCL,
+		guestTRUNC:  os.O_TRUNC,
+		guestAPPEND: os.O_APPEND,
+	} {
+		if flags&guest != 0 {
+			host |= h
+		}
+	}
+	return host
+}
+
 func (o *Open) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	fd, err := syscall.Open(fixPath(o.Path), o.Flags, o.Mode)
-	if fa.handleError(w, err, true) {
+	flags := hostFlags(o.Flags)
+	f, err := os.OpenFile(fa.resolvePath(o.Path), flags, os.FileMode(o.Mode))
+	if fa.handleError(w, err) {
 		return
 	}
-	response := map[string]any{"fd": fd}
+	if o.Flags&guestDIRECTORY != 0 {
+		info, err := f.Stat()
+		if err == nil && !info.IsDir() {
+			err = errNotDir
+		}
+		if err != nil {
+			f.Close()
+			fa.handleError(w, err)
+			return
+		}
+	}
+	response := map[string]any{"fd": fa.addFile(f, flags&os.O_APPEND != 0)}
 	fa.okResponse(response, w)
 }
 
@@ -147,38 +293,49 @@ type Fstat struct {
 	Fd int `json:"fd"`
 }
 
+// Write is sent as the raw data to write in the request body, with the
+// remaining parameters in the query string.
 type Write struct {
-	Fd       int    `json:"fd"`
-	Buffer   string `json:"buffer"`
-	Offset   int    `json:"offset"`
-	Length   int    `json:"length"`
-	Position *int   `json:"position,omitempty"`
+	Fd       int
+	Position *int64
+	Data     []byte
 }
 
-func (wr *Write) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	if wr.Offset != 0 {
-		fa.doError("not implemented", "ENOSYS", w,
-			fmt.Errorf("write offset %d not supported", wr.Offset))
-		return
+func (wr *Write) decode(r *http.Request) (err error) {
+	q := r.URL.Query()
+	if wr.Fd, err = strconv.Atoi(q.Get("fd")); err != nil {
+		return err
 	}
-	if wr.Position != nil {
-		_, err := syscall.Seek(FdType(wr.Fd), int64(*wr.Position), 0)
-		if err != nil {
-			fa.doError("not implemented", "ENOSYS", w, err)
-			return
-		}
+	if wr.Position, err = optionalInt64(q, "position"); err != nil {
+		return err
 	}
+	wr.Data, err = io.ReadAll(r.Body)
+	return err
+}
 
-	bytes, err := base64.StdEncoding.DecodeString(wr.Buffer)
-	if err != nil {
-		fa.doError("not implemented", "ENOSYS", w, err)
+func (wr *Write) String() string {
+	return fmt.Sprintf("{Fd:%d Position:%s Data:%d bytes}", wr.Fd, formatPosition(wr.Position), len(wr.Data))
+}
+
+// WriteResponse writes the request body. If Position is set, the data is
+// written there without moving the file offset, like pwrite. Like pwrite
+// on Linux, the data is appended to a file opened with O_APPEND instead.
+func (wr *Write) WriteResponse(fa *Handler, w http.ResponseWriter) {
+	f, err := fa.file(wr.Fd)
+	if fa.handleError(w, err) {
 		return
 	}
-
 	var written int
-	written, err = syscall.Write(FdType(wr.Fd), bytes)
-	if err != nil {
-		fa.doError("not implemented", "ENOSYS", w, err)
+	if wr.Position != nil && !fa.isAppending(wr.Fd) {
+		if *wr.Position < 0 {
+			fa.doError(syscall.EINVAL.Error(), "EINVAL", w, nil)
+			return
+		}
+		written, err = f.WriteAt(wr.Data, *wr.Position)
+	} else {
+		written, err = f.Write(wr.Data)
+	}
+	if fa.handleError(w, err) {
 		return
 	}
 
@@ -190,8 +347,12 @@ type Close struct {
 }
 
 func (c *Close) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	err := syscall.Close(FdType(c.Fd))
-	if fa.handleError(w, err, false) {
+	f, err := fa.removeFile(c.Fd)
+	if fa.handleError(w, err) {
+		return
+	}
+	err = f.Close()
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(map[string]any{}, w)
@@ -203,8 +364,8 @@ type Rename struct {
 }
 
 func (r *Rename) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	err := syscall.Rename(fixPath(r.From), fixPath(r.To))
-	if fa.handleError(w, err, true) {
+	err := syscall.Rename(fa.resolvePath(r.From), fa.resolvePath(r.To))
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(map[string]any{}, w)
@@ -215,8 +376,8 @@ type Readdir struct {
 }
 
 func (r *Readdir) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	entries, err := os.ReadDir(fixPath(r.Path))
-	if fa.handleError(w, err, false) {
+	entries, err := os.ReadDir(fa.resolvePath(r.Path))
+	if fa.handleError(w, err) {
 		return
 	}
 	stringNames := make([]string, len(entries))
@@ -230,39 +391,82 @@ type Lstat struct {
 	Path string `json:"path"`
 }
 
+// Read takes its parameters from the query string and responds with the
+// data read as the raw response body.
 type Read struct {
-	Fd       int  `json:"fd"`
-	Offset   int  `json:"offset"`
-	Length   int  `json:"length"`
-	Position *int `json:"position,omitempty"`
+	Fd       int
+	Length   int
+	Position *int64
 }
 
+func (r *Read) decode(req *http.Request) (err error) {
+	q := req.URL.Query()
+	if r.Fd, err = strconv.Atoi(q.Get("fd")); err != nil {
+		return err
+	}
+	if r.Length, err = strconv.Atoi(q.Get("length")); err != nil {
+		return err
+	}
+	r.Position, err = optionalInt64(q, "position")
+	return err
+}
+
+func (r *Read) String() string {
+	return fmt.Sprintf("{Fd:%d Length:%d Position:%s}", r.Fd, r.Length, formatPosition(r.Position))
+}
+
+// maxReadLength caps the data returned by a single read. Short reads are
+// fine for the guest, and it keeps the pooled buffers bounded.
+const maxReadLength = 1 << 20
+
+var readBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, maxReadLength)
+		return &buf
+	},
+}
+
+// WriteResponse reads up to Length bytes. If Position is set, the data is read
+// from there without moving the file offset, like pread.
 func (r *Read) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	if r.Offset != 0 {
-		fa.doError("not implemented", "ENOSYS", w,
-			fmt.Errorf("read offset %d not supported", r.Offset))
+	if r.Length < 0 {
+		fa.doError(syscall.EINVAL.Error(), "EINVAL", w,
+			fmt.Errorf("read length %d out of range", r.Length))
+		return
+	}
+
+	if isStdStream(r.Fd) {
+		// Nothing is ever typed in, stdin is at its end.
+		fa.okBinary(nil, w)
+		return
+	}
+	f, err := fa.file(r.Fd)
+	if fa.handleError(w, err) {
 		return
 	}
+
+	bufPtr := readBuffers.Get().(*[]byte)
+	defer readBuffers.Put(bufPtr)
+	buffer := (*bufPtr)[:min(r.Length, maxReadLength)]
+
+	var read int
 	if r.Position != nil {
-		_, err := syscall.Seek(FdType(r.Fd), int64(*r.Position), 0)
-		if err != nil {
-			fa.doError("not implemented", "ENOSYS", w, err)
+		if *r.Position < 0 {
+			fa.doError(syscall.EINVAL.Error(), "EINVAL", w, nil)
 			return
 		}
+		read, err = f.ReadAt(buffer, *r.Position)
+	} else {
+		read, err = f.Read(buffer)
 	}
-
-	buffer := make([]byte, r.Length)
-	read, err := syscall.Read(FdType(r.Fd), buffer)
-	if err != nil {
-		fa.doError("not implemented", "ENOSYS", w, err)
-		return
+	if err == io.EOF {
+		// The guest expects a read of 0 bytes at the end of the file.
+		err = nil
 	}
-	response := map[string]any{
-		"read":   read,
-		"buffer": base64.StdEncoding.EncodeToString(buffer[:read]),
+	if fa.handleError(w, err) {
+		return
 	}
-	fa.okResponse(response, w)
-
+	fa.okBinary(buffer[:read], w)
 }
 
 type Mkdir struct {
@@ -271,9 +475,8 @@ type Mkdir struct {
 }
 
 func (m *Mkdir) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	err := syscall.Mkdir(fixPath(m.Path), m.Perm)
-	if err != nil {
-		fa.doError("not implemented", "ENOSYS", w, err)
+	err := syscall.Mkdir(fa.resolvePath(m.Path), m.Perm)
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(map[string]any{}, w)
@@ -284,9 +487,8 @@ type Unlink struct {
 }
 
 func (u *Unlink) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	err := syscall.Unlink(fixPath(u.Path))
-	if err != nil {
-		fa.doError("not implemented", "ENOSYS", w, err)
+	err := syscall.Unlink(fa.resolvePath(u.Path))
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(map[string]any{}, w)
@@ -297,23 +499,50 @@ type Rmdir struct {
 }
 
 func (r *Rmdir) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	err := syscall.Rmdir(fixPath(r.Path))
-	if fa.handleError(w, err, true) {
+	err := syscall.Rmdir(fa.resolvePath(r.Path))
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(map[string]any{}, w)
 }
 
-func (fa *Handler) handleError(w http.ResponseWriter, err error, noEnt bool) bool {
+// Chdir changes the working directory of the guest.
+type Chdir struct {
+	Path string `json:"path"`
+}
+
+// WriteResponse changes the guest's working directory, which has to be an
+// existing directory, and responds with the new one.
+func (c *Chdir) WriteResponse(fa *Handler, w http.ResponseWriter) {
+	path := fa.resolvePath(c.Path)
+	info, err := os.Stat(path)
+	if fa.handleError(w, err) {
+		return
+	}
+	if !info.IsDir() {
+		fa.doError(syscall.ENOTDIR.Error(), "ENOTDIR", w, nil)
+		return
+	}
+	fa.cwdMu.Lock()
+	fa.cwd = path
+	fa.cwdMu.Unlock()
+	fa.okResponse(map[string]any{"cwd": path}, w)
+}
+
+// handleError reports err to the guest with the errno it maps to and returns
+// true, or returns false if err is nil.
+func (fa *Handler) handleError(w http.ResponseWriter, err error) bool {
 	if err == nil {
 		return false
 	}
-	if noEnt && os.IsNotExist(err) {
-		// We're not passing the error down for logging here since this is a
-		// file not found condition, not an actual error condition.
-		fa.doError(syscall.ENOENT.Error(), "ENOENT", w, nil)
+	code, msg := errnoOf(err)
+	if code == "ENOSYS" {
+		fa.doError(msg, code, w, err)
 	} else {
-		fa.doError(syscall.ENOSYS.Error(), "ENOSYS", w, err)
+		// We're not passing the error down for logging here since errors
+		// with a known errno are conditions the guest is expected to handle,
+		// like a missing file, not actual error conditions.
+		fa.doError(msg, code, w, nil)
 	}
 	return true
 }
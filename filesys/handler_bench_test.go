@@ -0,0 +1,98 @@
+package filesys
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// The benchmarks go through a real HTTP server, like the browser does, so
+// ns/op is the latency of one call and MB/s the throughput of the bridge.
+
+var benchSizes = []int{4 << 10, 64 << 10, 1 << 20}
+
+func BenchmarkRead(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			bench := newBench(b, bytes.Repeat([]byte("x"), size))
+			query := "fd=" + strconv.Itoa(bench.fd) + "&position=0&length=" + strconv.Itoa(size)
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				body := bench.call("read", query, nil)
+				if len(body) != size {
+					b.Fatalf("read %d bytes, expected %d", len(body), size)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			bench := newBench(b, nil)
+			query := "fd=" + strconv.Itoa(bench.fd) + "&position=0"
+			data := bytes.Repeat([]byte("x"), size)
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bench.call("write", query, data)
+			}
+		})
+	}
+}
+
+type benchApi struct {
+	b      *testing.B
+	client *http.Client
+	url    string
+	fd     int
+}
+
+// newBench serves a handler and opens a file with the given contents through it.
+func newBench(b *testing.B, contents []byte) *benchApi {
+	path := filepath.Join(b.TempDir(), "bench")
+	if err := os.WriteFile(path, contents, 0644); err != nil {
+		b.Fatal(err)
+	}
+	server := httptest.NewServer(NewHandler(TOKEN, log.New(io.Discard, "", 0)))
+	b.Cleanup(server.Close)
+
+	bench := &benchApi{b: b, client: server.Client(), url: server.URL}
+	body := bench.call("open", "", []byte(fmt.Sprintf(`{"path":%q,"flags":%d}`, path, guestRDWR)))
+	if _, err := fmt.Sscanf(string(body), `{"fd":%d}`, &bench.fd); err != nil {
+		b.Fatal(err)
+	}
+	return bench
+}
+
+func (ba *benchApi) call(api, query string, body []byte) []byte {
+	req, err := http.NewRequest(http.MethodPost, ba.url+"/fs/"+api+"?"+query, bytes.NewReader(body))
+	if err != nil {
+		ba.b.Fatal(err)
+	}
+	req.Header.Set("WBT-Token", TOKEN)
+	resp, err := ba.client.Do(req)
+	if err != nil {
+		ba.b.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ba.b.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		ba.b.Fatalf("%s failed: %s", api, data)
+	}
+	return data
+}
@@ -2,7 +2,6 @@ package filesys
 
 import (
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,7 +12,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"runtime"
+	"strings"
 	"sync"
 	"syscall"
 	"testing"
@@ -23,7 +22,7 @@ const TOKEN = "test_token"
 
 func TestOpen_Missing(t *testing.T) {
 	help := Helper(t)
-	o := &Open{Path: help.tempPath("not_found.txt"), Flags: os.O_RDONLY, Mode: 0}
+	o := &Open{Path: help.tempPath("not_found.txt"), Flags: 0, Mode: 0}
 	response := &ErrorCode{}
 
 	help.httpBad(help.req("open", o, response))
@@ -33,7 +32,7 @@ func TestOpen_Missing(t *testing.T) {
 func TestOpenClose(t *testing.T) {
 	help := Helper(t)
 	path := help.createFile("found.txt", "some data")
-	o := &Open{Path: path, Flags: os.O_RDONLY, Mode: 0}
+	o := &Open{Path: path, Flags: 0, Mode: 0}
 	c := &Close{}
 
 	help.httpOk(help.req("open", o, c))
@@ -66,11 +65,12 @@ func TestStat_Missing(t *testing.T) {
 func TestFstat(t *testing.T) {
 	help := Helper(t)
 	tempFile := help.createFile("exists", "some data")
-	fd, closeFd := help.sysOpen(tempFile)
-	defer closeFd()
+	openMap := help.newMap()
+	help.httpOk(help.req("open", &Open{Path: tempFile}, &openMap))
+	defer help.deferCloseFd(openMap)
 
 	m := help.newMap()
-	fstat := map[string]any{"fd": fd}
+	fstat := map[string]any{"fd": openMap["fd"]}
 
 	help.httpOk(help.req("fstat", fstat, &m))
 	help.checkStatMap(m, tempFile)
@@ -80,6 +80,21 @@ func TestFstat(t *testing.T) {
 	help.httpBad(help.req("fstat", fstat, &m))
 }
 
+func TestStdStreams(t *testing.T) {
+	help := Helper(t)
+	for fd := 0; fd < firstFd; fd++ {
+		m := help.newMap()
+		help.httpOk(help.req("fstat", &Fstat{Fd: fd}, &m))
+		mode := uint32(m["mode"].(float64))
+		help.true(mode&0o170000 == 0o20000, fmt.Sprintf("fd %d is not a character device: mode %o", fd, mode))
+	}
+
+	// stdin is at its end
+	data := []byte("unchanged")
+	help.httpOk(help.req("read", help.raw(map[string]any{"fd": 0, "length": 8}, ""), &data))
+	help.true(len(data) == 0, fmt.Sprintf("read %q from stdin", data))
+}
+
 func TestLstat(t *testing.T) {
 	help := Helper(t)
 	exists := help.createFile("exists.txt", "some data")
@@ -137,14 +152,13 @@ func TestWrite(t *testing.T) {
 	help := Helper(t)
 	writtenFile := help.tempPath("written.txt")
 	openMap := help.newMap()
-	payload := &Open{Path: writtenFile, Flags: os.O_RDWR | os.O_CREATE | os.O_TRUNC, Mode: 0777}
+	payload := &Open{Path: writtenFile, Flags: guestRDWR | guestCREAT | guestTRUNC, Mode: 0777}
 
 	help.httpOk(help.req("open", payload, &openMap))
 	defer help.deferCloseFd(openMap)
 
 	contents := "some sample file contents"
-	buffer := base64.StdEncoding.EncodeToString([]byte(contents))
-	w := map[string]any{"fd": openMap["fd"], "buffer": buffer, "length": len(contents)}
+	w := help.raw(map[string]any{"fd": openMap["fd"]}, contents)
 
 	writeResult := help.newMap()
 	help.httpOk(help.req("write", w, &writeResult))
@@ -158,13 +172,12 @@ func TestWrite_with_position(t *testing.T) {
 	help := Helper(t)
 	writtenFile := help.createFile("writeSeek.txt", "1234567890")
 	openMap := help.newMap()
-	payload := &Open{Path: writtenFile, Flags: os.O_RDWR, Mode: 0777}
+	payload := &Open{Path: writtenFile, Flags: guestRDWR, Mode: 0777}
 
 	help.httpOk(help.req("open", payload, &openMap))
 
 	contents := "ZZZ"
-	buffer := base64.StdEncoding.EncodeToString([]byte(contents))
-	w := map[string]any{"fd": openMap["fd"], "position": 5, "buffer": buffer, "length": len(contents)}
+	w := help.raw(map[string]any{"fd": openMap["fd"], "position": 5}, contents)
 
 	writeResult := help.newMap()
 	help.httpOk(help.req("write", w, &writeResult))
@@ -184,15 +197,16 @@ func TestWrite_bad(t *testing.T) {
 	help := Helper(t)
 	writtenFile := help.tempPath("written.txt")
 	openMap := help.newMap()
-	payload := &Open{Path: writtenFile, Flags: os.O_RDWR | os.O_CREATE | os.O_TRUNC, Mode: 0777}
+	payload := &Open{Path: writtenFile, Flags: guestRDWR | guestCREAT | guestTRUNC, Mode: 0777}
 
 	help.httpOk(help.req("open", payload, &openMap))
 	defer help.deferCloseFd(openMap)
 
 	// failing test cases
-	help.httpBad(help.req("write", &Write{Offset: 1}, &ErrorCode{}))
-	help.httpBad(help.req("write", &Write{Buffer: "%%%"}, &ErrorCode{}))
-	help.httpBad(help.req("write", &Write{Buffer: ""}, &ErrorCode{}))
+	help.httpBad(help.req("write", help.raw(map[string]any{"fd": "%%%"}, "data"), &ErrorCode{}))
+	help.httpBad(help.req("write", help.raw(map[string]any{"fd": openMap["fd"], "position": "x"}, "data"), &ErrorCode{}))
+	help.httpBad(help.req("write", help.raw(map[string]any{"fd": openMap["fd"], "position": -1}, "data"), &ErrorCode{}))
+	help.httpBad(help.req("write", help.raw(map[string]any{"fd": math.MaxInt32}, "data"), &ErrorCode{}))
 }
 
 func TestClose_bad(t *testing.T) {
@@ -210,10 +224,10 @@ func TestMkdir(t *testing.T) {
 	help.exists(payload.Path)
 
 	// mkdir without parent directory should fail
-	if runtime.GOOS != "windows" {
-		payload = &Mkdir{Path: help.tempPath("nested/levels")}
-		help.httpBad(help.req("mkdir", payload, &ErrorCode{}))
-	}
+	response := &ErrorCode{}
+	payload = &Mkdir{Path: help.tempPath("missing/levels")}
+	help.httpBad(help.req("mkdir", payload, response))
+	help.errorCode(response.Code, "ENOENT")
 }
 
 func TestRmdir(t *testing.T) {
@@ -243,11 +257,6 @@ func TestUnlink(t *testing.T) {
 	help.httpBad(help.req("unlink", payload, &ErrorCode{}))
 }
 
-type readResult struct {
-	Read   int    `json:"read"`
-	Buffer string `json:"buffer"`
-}
-
 func TestRead(t *testing.T) {
 	help := Helper(t)
 
@@ -257,29 +266,202 @@ func TestRead(t *testing.T) {
 	help.httpOk(help.req("open", &Open{Path: tmpFile}, &m))
 	defer help.deferCloseFd(m)
 
-	readMap := map[string]any{"fd": m["fd"], "offset": 0, "length": len(content)}
-	resultMap := &readResult{}
-	help.httpOk(help.req("read", readMap, &resultMap))
-	help.true(resultMap.Read == len(content), "read length incorrect")
+	var data []byte
+	readMap := map[string]any{"fd": m["fd"], "length": len(content)}
+	help.httpOk(help.req("read", help.raw(readMap, ""), &data))
+	help.true(string(data) == content, "read data did not match")
 
-	decodedRead, errDecode := base64.StdEncoding.DecodeString(resultMap.Buffer)
-	help.nilErr(errDecode).true(string(decodedRead) == content, "read data did not match")
+	readMap = map[string]any{"fd": m["fd"], "position": 1, "length": len(content) - 1}
+	help.httpOk(help.req("read", help.raw(readMap, ""), &data))
+	help.true(string(data) == content[1:], "read data did not match")
 
-	readMap = map[string]any{"fd": m["fd"], "offset": 0, "position": 1, "length": len(content) - 1}
-	help.httpOk(help.req("read", readMap, &resultMap))
-	decodedRead, errDecode = base64.StdEncoding.DecodeString(resultMap.Buffer)
-	help.nilErr(errDecode).true(string(decodedRead) == content[1:], "read data did not match")
+	readMap = map[string]any{"fd": m["fd"], "length": -1}
+	help.httpBad(help.req("read", help.raw(readMap, ""), &ErrorCode{}))
 
-	readMap = map[string]any{"fd": m["fd"], "offset": 1, "length": len(content) - 1}
-	help.httpBad(help.req("read", readMap, &resultMap))
-
-	readMap = map[string]any{"fd": m["fd"], "offset": 0, "position": -1, "length": 1}
-	help.httpBad(help.req("read", readMap, &resultMap))
+	readMap = map[string]any{"fd": m["fd"], "position": -1, "length": 1}
+	help.httpBad(help.req("read", help.raw(readMap, ""), &ErrorCode{}))
 
 	// read on bad file descriptor
-	readMap = map[string]any{"fd": math.MaxInt64, "offset": 0, "length": len(content)}
-	help.httpBad(help.req("read", readMap, &resultMap))
+	readMap = map[string]any{"fd": math.MaxInt32, "length": len(content)}
+	help.httpBad(help.req("read", help.raw(readMap, ""), &ErrorCode{}))
+
+	// missing parameters
+	help.httpBad(help.req("read", help.raw(map[string]any{"fd": m["fd"]}, ""), &ErrorCode{}))
+}
+
+func TestRead_position_keeps_offset(t *testing.T) {
+	help := Helper(t)
+
+	content := "0123456789"
+	m := help.newMap()
+	help.httpOk(help.req("open", &Open{Path: help.createFile("file.txt", content)}, &m))
+	defer help.deferCloseFd(m)
 
+	read := func(query map[string]any, expected string) {
+		help.t.Helper()
+		var data []byte
+		help.httpOk(help.req("read", help.raw(query, ""), &data))
+		help.true(string(data) == expected, fmt.Sprintf("read %q, expected %q", data, expected))
+	}
+
+	// a plain read advances the file offset
+	read(map[string]any{"fd": m["fd"], "length": 2}, "01")
+	// a positional read does not
+	read(map[string]any{"fd": m["fd"], "length": 3, "position": 6}, "678")
+	read(map[string]any{"fd": m["fd"], "length": 2}, "23")
+	// reading past the end of the file returns no data
+	read(map[string]any{"fd": m["fd"], "length": 4, "position": 100}, "")
+}
+
+func TestRead_large(t *testing.T) {
+	help := Helper(t)
+
+	content := strings.Repeat("x", maxReadLength+10)
+	m := help.newMap()
+	help.httpOk(help.req("open", &Open{Path: help.createFile("file.txt", content)}, &m))
+	defer help.deferCloseFd(m)
+
+	// reads are capped, the guest is expected to handle short reads
+	var data []byte
+	help.httpOk(help.req("read", help.raw(map[string]any{"fd": m["fd"], "length": len(content)}, ""), &data))
+	help.true(len(data) == maxReadLength, fmt.Sprintf("read %d bytes, expected %d", len(data), maxReadLength))
+	help.httpOk(help.req("read", help.raw(map[string]any{"fd": m["fd"], "length": len(content)}, ""), &data))
+	help.true(len(data) == 10, fmt.Sprintf("read %d bytes, expected %d", len(data), 10))
+}
+
+func TestWrite_position_keeps_offset(t *testing.T) {
+	help := Helper(t)
+	path := help.createFile("file.txt", "")
+	m := help.newMap()
+	help.httpOk(help.req("open", &Open{Path: path, Flags: guestRDWR}, &m))
+
+	write := func(data string, position any) {
+		help.t.Helper()
+		query := map[string]any{"fd": m["fd"]}
+		if position != nil {
+			query["position"] = position
+		}
+		result := help.newMap()
+		help.httpOk(help.req("write", help.raw(query, data), &result))
+		help.true(int(result["written"].(float64)) == len(data), "incorrect written length")
+	}
+
+	write("0123456789", nil)
+	write("ab", 2)
+	// the positional write did not move the file offset, so this appends
+	write("XY", nil)
+	help.httpOk(help.req("close", m, &ErrorCode{}))
+
+	file, err := os.ReadFile(path)
+	help.nilErr(err)
+	help.true(string(file) == "01ab456789XY", fmt.Sprintf("expected 01ab456789XY but got %q", string(file)))
+}
+
+func TestWrite_append(t *testing.T) {
+	help := Helper(t)
+	path := help.createFile("append.txt", "0123")
+	m := help.newMap()
+	help.httpOk(help.req("open", &Open{Path: path, Flags: guestWRONLY | guestAPPEND}, &m))
+
+	for _, position := range []any{nil, 1} {
+		query := map[string]any{"fd": m["fd"]}
+		if position != nil {
+			query["position"] = position
+		}
+		result := help.newMap()
+		help.httpOk(help.req("write", help.raw(query, "ab"), &result))
+		help.true(int(result["written"].(float64)) == 2, "incorrect written length")
+	}
+	help.httpOk(help.req("close", m, &ErrorCode{}))
+
+	// like pwrite on Linux, the positional write appends too
+	file, err := os.ReadFile(path)
+	help.nilErr(err)
+	help.true(string(file) == "0123abab", fmt.Sprintf("expected 0123abab but got %q", string(file)))
+}
+
+func TestHostFlags(t *testing.T) {
+	for guest, expected := range map[int]int{
+		0:                                       os.O_RDONLY,
+		guestWRONLY | guestAPPEND:               os.O_WRONLY | os.O_APPEND,
+		guestRDWR | guestCREAT | guestEXCL:      os.O_RDWR | os.O_CREATE | os.O_EXCL,
+		guestRDWR | guestTRUNC | guestDIRECTORY: os.O_RDWR | os.O_TRUNC,
+	} {
+		if host := hostFlags(guest); host != expected {
+			t.Errorf("hostFlags(%#o) = %#x, expected %#x", guest, host, expected)
+		}
+	}
+}
+
+func TestChdir(t *testing.T) {
+	help := Helper(t)
+	cwd, err := os.Getwd()
+	help.nilErr(err)
+	help.true(help.handler.Cwd() == cwd, "session does not start in the working directory")
+
+	help.nilErr(os.MkdirAll(help.tempPath("a/b"), 0755))
+	help.createFile("a/b/file.txt", "data")
+
+	m := help.newMap()
+	help.httpOk(help.req("chdir", &Chdir{Path: "/fs/" + help.tempPath("a")}, &m))
+	help.true(m["cwd"] == help.tempPath("a"), fmt.Sprintf("unexpected cwd %v", m["cwd"]))
+
+	// relative to the new working directory
+	help.httpOk(help.req("chdir", &Chdir{Path: "/fs/b"}, &m))
+	help.true(help.handler.Cwd() == help.tempPath("a/b"), "incorrect cwd "+help.handler.Cwd())
+	help.httpOk(help.req("stat", &Stat{Path: "/fs/file.txt"}, &m))
+	help.httpOk(help.req("chdir", &Chdir{Path: "/fs/.."}, &m))
+	help.true(help.handler.Cwd() == help.tempPath("a"), "incorrect cwd "+help.handler.Cwd())
+
+	response := &ErrorCode{}
+	help.httpBad(help.req("chdir", &Chdir{Path: "/fs/missing"}, response))
+	help.errorCode(response.Code, "ENOENT")
+	help.httpBad(help.req("chdir", &Chdir{Path: "/fs/b/file.txt"}, response))
+	help.errorCode(response.Code, "ENOTDIR")
+	help.true(help.handler.Cwd() == help.tempPath("a"), "cwd changed by a failed chdir")
+
+	help.httpBad(help.req("stat", &Stat{Path: "/fs/"}, response))
+	help.errorCode(response.Code, "ENOENT")
+}
+
+func TestHostFd_rejected(t *testing.T) {
+	help := Helper(t)
+	tempFile := help.createFile("exists", "some data")
+	fd, closeFd := help.sysOpen(tempFile)
+	defer closeFd()
+
+	// a descriptor of the host process is unknown to the session
+	for _, api := range []string{"fstat", "close"} {
+		response := &ErrorCode{}
+		help.httpBad(help.req(api, map[string]any{"fd": fd}, response))
+		help.errorCode(response.Code, "EBADF")
+	}
+	for _, api := range []string{"read", "write"} {
+		response := &ErrorCode{}
+		help.httpBad(help.req(api, help.raw(map[string]any{"fd": fd, "length": 1}, "x"), response))
+		help.errorCode(response.Code, "EBADF")
+	}
+}
+
+func TestCloseFiles(t *testing.T) {
+	help := Helper(t)
+	first, second := help.createFile("first", "data"), help.createFile("second", "data")
+	firstMap, secondMap := help.newMap(), help.newMap()
+	help.httpOk(help.req("open", &Open{Path: first}, &firstMap))
+	help.httpOk(help.req("open", &Open{Path: second}, &secondMap))
+	help.true(firstMap["fd"].(float64) >= firstFd, "descriptor of a standard stream returned")
+	help.true(firstMap["fd"] != secondMap["fd"], "descriptor reused")
+	help.httpOk(help.req("close", firstMap, &ErrorCode{}))
+
+	leaked := help.handler.CloseFiles()
+	expected := fmt.Sprintf("fd %d: %s", int(secondMap["fd"].(float64)), second)
+	help.true(len(leaked) == 1 && leaked[0] == expected, fmt.Sprintf("unexpected leak report %q", leaked))
+
+	// the leaked descriptor is closed
+	response := &ErrorCode{}
+	help.httpBad(help.req("close", secondMap, response))
+	help.errorCode(response.Code, "EBADF")
+	help.true(len(help.handler.CloseFiles()) == 0, "files left after CloseFiles")
 }
 
 func Test_handle(t *testing.T) {
@@ -371,6 +553,7 @@ func Helper(t *testing.T) *helperApi {
 	}
 	help.handler = NewHandler(TOKEN, logger)
 	help.handler.debug = true
+	t.Cleanup(func() { help.handler.CloseFiles() })
 	help.tmpDir = t.TempDir()
 	return help
 }
@@ -382,6 +565,23 @@ type helperApi struct {
 	tmpDir  string
 }
 
+// rawRequest is a payload for the apis which take their parameters from the
+// query string and raw data as the request body.
+type rawRequest struct {
+	query url.Values
+	body  []byte
+}
+
+func (h *helperApi) raw(query map[string]any, body string) rawRequest {
+	values := url.Values{}
+	for k, v := range query {
+		values.Set(k, fmt.Sprint(v))
+	}
+	return rawRequest{query: values, body: []byte(body)}
+}
+
+// req calls the api with the payload. If response is a *[]byte, a successful
+// response body is stored in it as is, otherwise the response is json decoded.
 func (h *helperApi) req(path string, payload any, response any) (code int) {
 	h.t.Helper()
 
@@ -390,13 +590,23 @@ func (h *helperApi) req(path string, payload any, response any) (code int) {
 	header := make(http.Header)
 	header.Set("WBT-Token", TOKEN)
 
-	body, err := json.Marshal(payload)
-	h.nilErr(err)
+	var body []byte
+	if raw, ok := payload.(rawRequest); ok {
+		u.RawQuery = raw.query.Encode()
+		body = raw.body
+	} else {
+		body, err = json.Marshal(payload)
+		h.nilErr(err)
+	}
 
 	req := &http.Request{URL: u, Header: header, Body: io.NopCloser(bytes.NewReader(body))}
 	w := &httptest.ResponseRecorder{Body: &bytes.Buffer{}}
 	h.handler.ServeHTTP(w, req)
 
+	if data, ok := response.(*[]byte); ok && w.Code == http.StatusOK {
+		*data = w.Body.Bytes()
+		return w.Code
+	}
 	err = json.Unmarshal(w.Body.Bytes(), response)
 	h.nilErr(err)
 	return w.Code
@@ -9,17 +9,25 @@ import (
 
 func (st *Stat) WriteResponse(fa *Handler, w http.ResponseWriter) {
 	s := &syscall.Stat_t{}
-	err := syscall.Stat(fixPath(st.Path), s)
-	if fa.handleError(w, err, true) {
+	err := syscall.Stat(fa.resolvePath(st.Path), s)
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(mapOfStatT(s), w)
 }
 
 func (f *Fstat) WriteResponse(fa *Handler, w http.ResponseWriter) {
+	if isStdStream(f.Fd) {
+		fa.okResponse(stdStreamStat(), w)
+		return
+	}
+	file, err := fa.file(f.Fd)
+	if fa.handleError(w, err) {
+		return
+	}
 	s := &syscall.Stat_t{}
-	err := syscall.Fstat(f.Fd, s)
-	if fa.handleError(w, err, false) {
+	err = syscall.Fstat(int(file.Fd()), s)
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(mapOfStatT(s), w)
@@ -27,8 +35,8 @@ func (f *Fstat) WriteResponse(fa *Handler, w http.ResponseWriter) {
 
 func (ls *Lstat) WriteResponse(fa *Handler, w http.ResponseWriter) {
 	s := &syscall.Stat_t{}
-	err := syscall.Lstat(fixPath(ls.Path), s)
-	if fa.handleError(w, err, true) {
+	err := syscall.Lstat(fa.resolvePath(ls.Path), s)
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(mapOfStatT(s), w)
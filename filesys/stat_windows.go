@@ -10,25 +10,33 @@ import (
 )
 
 func (st *Stat) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	stat, err := os.Stat(fixPath(st.Path))
-	if fa.handleError(w, err, true) {
+	stat, err := os.Stat(fa.resolvePath(st.Path))
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(mapOfFileInfo(stat), w)
 }
 
 func (f *Fstat) WriteResponse(fa *Handler, w http.ResponseWriter) {
+	if isStdStream(f.Fd) {
+		fa.okResponse(stdStreamStat(), w)
+		return
+	}
+	file, err := fa.file(f.Fd)
+	if fa.handleError(w, err) {
+		return
+	}
 	fileInfo := &syscall.ByHandleFileInformation{}
-	err := syscall.GetFileInformationByHandle(FdType(f.Fd), fileInfo)
-	if fa.handleError(w, err, true) {
+	err = syscall.GetFileInformationByHandle(syscall.Handle(file.Fd()), fileInfo)
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(mapOfByHandleFileInformation(fileInfo), w)
 }
 
 func (ls *Lstat) WriteResponse(fa *Handler, w http.ResponseWriter) {
-	stat, err := os.Stat(fixPath(ls.Path))
-	if fa.handleError(w, err, true) {
+	stat, err := os.Stat(fa.resolvePath(ls.Path))
+	if fa.handleError(w, err) {
 		return
 	}
 	fa.okResponse(mapOfFileInfo(stat), w)
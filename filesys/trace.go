@@ -0,0 +1,213 @@
+package filesys
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Tracer writes a strace-like log line for every api call of a Handler,
+// and a summary of the calls per operation at the end of the session.
+type Tracer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	stats map[string]*opStats
+}
+
+type opStats struct {
+	calls  int
+	errors int
+	total  time.Duration
+}
+
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{
+		w:     w,
+		start: time.Now(),
+		stats: make(map[string]*opStats),
+	}
+}
+
+// SetTracer makes the handler trace every api call to t.
+func (fa *Handler) SetTracer(t *Tracer) {
+	fa.tracer = t
+}
+
+// traced is implemented by requests to describe their arguments in the trace,
+// with paths resolved to host paths and descriptors annotated with their file.
+type traced interface {
+	traceArgs(fa *Handler) string
+}
+
+// record writes a log line for a finished call.
+func (t *Tracer) record(op, args string, start time.Time, duration time.Duration, result string, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.stats[op]
+	if !ok {
+		s = &opStats{}
+		t.stats[op] = s
+	}
+	s.calls++
+	s.total += duration
+	if failed {
+		s.errors++
+	}
+	// Trace lines are best effort, a failing writer must not fail the call.
+	_, _ = fmt.Fprintf(t.w, "+%.6fs %s(%s) = %s <%.6fs>\n",
+		start.Sub(t.start).Seconds(), op, args, result, duration.Seconds())
+}
+
+// WriteSummary writes the number of calls, errors and the time spent per
+// operation, the most expensive operation first.
+func (t *Tracer) WriteSummary() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ops := make([]string, 0, len(t.stats))
+	var total opStats
+	for op, s := range t.stats {
+		ops = append(ops, op)
+		total.calls += s.calls
+		total.errors += s.errors
+		total.total += s.total
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if t.stats[ops[i]].total != t.stats[ops[j]].total {
+			return t.stats[ops[i]].total > t.stats[ops[j]].total
+		}
+		return ops[i] < ops[j]
+	})
+
+	tw := tabwriter.NewWriter(t.w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "calls\terrors\ttotal\tavg\top\t\n")
+	line := func(op string, s *opStats) {
+		var avg time.Duration
+		if s.calls > 0 {
+			avg = s.total / time.Duration(s.calls)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t\n", s.calls, s.errors, s.total, avg, op)
+	}
+	for _, op := range ops {
+		line(op, t.stats[op])
+	}
+	line("total", &total)
+	return tw.Flush()
+}
+
+// traceResponseWriter keeps what the trace needs to know of a response.
+type traceResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	binary int
+}
+
+func (tw *traceResponseWriter) WriteHeader(status int) {
+	tw.status = status
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *traceResponseWriter) Write(p []byte) (int, error) {
+	if tw.ResponseWriter.Header().Get("Content-Type") == "application/octet-stream" {
+		tw.binary += len(p)
+	} else {
+		tw.body.Write(p)
+	}
+	return tw.ResponseWriter.Write(p)
+}
+
+// result describes the response like strace describes a return value.
+func (tw *traceResponseWriter) result() (result string, failed bool) {
+	if tw.status != http.StatusOK {
+		var e ErrorCode
+		if err := json.Unmarshal(tw.body.Bytes(), &e); err == nil {
+			return fmt.Sprintf("%s (%s)", e.Code, e.Error), true
+		}
+		return fmt.Sprintf("HTTP %d", tw.status), true
+	}
+	if tw.body.Len() == 0 {
+		return fmt.Sprintf("%d bytes", tw.binary), false
+	}
+	const maxResult = 120
+	result = strings.TrimSpace(tw.body.String())
+	if len(result) > maxResult {
+		result = result[:maxResult] + "..."
+	}
+	return result, false
+}
+
+// fdName formats fd like strace -y does, with the path of its file.
+func (fa *Handler) fdName(fd int) string {
+	f, err := fa.file(fd)
+	if err != nil {
+		return fmt.Sprint(fd)
+	}
+	return fmt.Sprintf("%d<%s>", fd, f.Name())
+}
+
+func formatPositionArg(position *int64) string {
+	if position == nil {
+		return ""
+	}
+	return fmt.Sprintf(", position=%d", *position)
+}
+
+func (st *Stat) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%q", fa.resolvePath(st.Path))
+}
+
+func (f *Fstat) traceArgs(fa *Handler) string {
+	return fa.fdName(f.Fd)
+}
+
+func (ls *Lstat) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%q", fa.resolvePath(ls.Path))
+}
+
+func (o *Open) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%q, flags=%#o, mode=%#o", fa.resolvePath(o.Path), o.Flags, o.Mode)
+}
+
+func (wr *Write) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%s, %d bytes%s", fa.fdName(wr.Fd), len(wr.Data), formatPositionArg(wr.Position))
+}
+
+func (c *Close) traceArgs(fa *Handler) string {
+	return fa.fdName(c.Fd)
+}
+
+func (r *Rename) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%q, %q", fa.resolvePath(r.From), fa.resolvePath(r.To))
+}
+
+func (r *Readdir) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%q", fa.resolvePath(r.Path))
+}
+
+func (r *Read) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%s, length=%d%s", fa.fdName(r.Fd), r.Length, formatPositionArg(r.Position))
+}
+
+func (m *Mkdir) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%q, perm=%#o", fa.resolvePath(m.Path), m.Perm)
+}
+
+func (u *Unlink) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%q", fa.resolvePath(u.Path))
+}
+
+func (r *Rmdir) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%q", fa.resolvePath(r.Path))
+}
+
+func (c *Chdir) traceArgs(fa *Handler) string {
+	return fmt.Sprintf("%q", fa.resolvePath(c.Path))
+}
@@ -0,0 +1,51 @@
+package filesys
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	help := Helper(t)
+	var out bytes.Buffer
+	tracer := NewTracer(&out)
+	help.handler.SetTracer(tracer)
+
+	path := help.createFile("file.txt", "some data")
+	m := help.newMap()
+	help.httpOk(help.req("open", &Open{Path: path}, &m))
+	var data []byte
+	help.httpOk(help.req("read", help.raw(map[string]any{"fd": m["fd"], "length": 4, "position": 2}, ""), &data))
+	help.httpOk(help.req("close", m, &ErrorCode{}))
+	help.httpBad(help.req("stat", &Stat{Path: help.tempPath("missing")}, &ErrorCode{}))
+	help.nilErr(tracer.WriteSummary())
+
+	fd := int(m["fd"].(float64))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	for i, expected := range []string{
+		fmt.Sprintf(`open(%q, flags=0, mode=0) = {"fd":%d}`, path, fd),
+		fmt.Sprintf(`read(%d<%s>, length=4, position=2) = 4 bytes`, fd, path),
+		fmt.Sprintf(`close(%d<%s>) = {}`, fd, path),
+		fmt.Sprintf(`stat(%q) = ENOENT (no such file or directory)`, help.tempPath("missing")),
+	} {
+		pattern := `^\+\d+\.\d{6}s ` + regexp.QuoteMeta(expected) + ` <\d+\.\d{6}s>$`
+		if len(lines) <= i || !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Fatalf("trace line %d does not match %q:\n%s", i, expected, out.String())
+		}
+	}
+
+	summary := strings.Join(lines[4:], "\n")
+	for _, pattern := range []string{
+		`calls\s+errors\s+total\s+avg\s+op`,
+		`\n\s+1\s+0\s+\S+\s+\S+\s+open`,
+		`\n\s+1\s+1\s+\S+\s+\S+\s+stat`,
+		`\n\s+4\s+1\s+\S+\s+\S+\s+total$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(summary) {
+			t.Errorf("summary does not match %q:\n%s", pattern, summary)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// runnerFlagPrefix is the prefix of the runner flags after the wasm file.
+// Flags with this prefix are never passed to the program.
+const runnerFlagPrefix = "wbt."
+
+const usageHeader = `usage: wasmbrowsertest [flags] <wasm file> [program flags]
+       wasmbrowsertest [flags] config
+
+Runs a js/wasm program, usually a test binary, in the browser. The config
+command prints the effective configuration.
+
+The flags can also be given after the wasm file with the -%s prefix, like
+-%sheadless=off, they win over the flags before it then.
+
+Flags:
+`
+
+// parseRunnerFlags parses the runner flags between the runner and the wasm
+// file, like go test -exec "wasmbrowsertest -timeout=5m" passes them. It
+// returns args without them. flag.ErrHelp is returned once the usage is
+// written, for -help.
+func (c *config) parseRunnerFlags(args []string, output io.Writer) ([]string, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprintf(output, usageHeader, runnerFlagPrefix, runnerFlagPrefix)
+		fs.PrintDefaults()
+	}
+	c.registerFlags(fs, "")
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	return append([]string{args[0]}, fs.Args()...), nil
+}
+
+// checkReservedFlags returns an error for a flag with the runner prefix
+// which is left in the program arguments, those are typos of runner flags.
+func checkReservedFlags(args []string) error {
+	for _, arg := range args {
+		if arg == "--" {
+			return nil
+		}
+		name := strings.TrimLeft(arg, "-")
+		if len(name) < len(arg) && len(arg)-len(name) <= 2 && strings.HasPrefix(name, runnerFlagPrefix) {
+			name, _, _ = strings.Cut(name, "=")
+			return fmt.Errorf("flag provided but not defined: -%s, see wasmbrowsertest -help", name)
+		}
+	}
+	return nil
+}
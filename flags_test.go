@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRunnerFlags(t *testing.T) {
+	c := config{Browser: browserConfig{Headless: "new"}}
+	var output bytes.Buffer
+	args, err := c.parseRunnerFlags([]string{
+		"wasmbrowsertest", "-timeout=5m", "-browser", "/usr/bin/chromium",
+		"-browser-flag=--no-sandbox", "pkg.test", "-test.v", "-timeout=1s",
+	}, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"wasmbrowsertest", "pkg.test", "-test.v", "-timeout=1s"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("got arguments %v, expected %v", args, expected)
+	}
+	expected := config{
+		Browser: browserConfig{Path: "/usr/bin/chromium", Flags: []string{"--no-sandbox"}, Headless: "new"},
+		Timeout: duration(5 * time.Minute),
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("got %+v, expected %+v", c, expected)
+	}
+
+	// The prefixed flags after the wasm file win.
+	fs := flag.NewFlagSet("wasmbrowsertest", flag.ContinueOnError)
+	c.registerFlags(fs, runnerFlagPrefix)
+	if _, err := gentleParse(fs, []string{"-wbt.timeout=1m", "-wbt.browser-flag=--mute-audio"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Timeout != duration(time.Minute) || !reflect.DeepEqual(c.Browser.Flags, []string{"--no-sandbox", "--mute-audio"}) {
+		t.Errorf("unexpected config %+v", c)
+	}
+	if output.Len() != 0 {
+		t.Errorf("unexpected output %q", output.String())
+	}
+}
+
+func TestParseRunnerFlags_help(t *testing.T) {
+	var c config
+	var output bytes.Buffer
+	_, err := c.parseRunnerFlags([]string{"wasmbrowsertest", "-help"}, &output)
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("got %v, expected %v", err, flag.ErrHelp)
+	}
+	for _, s := range []string{"usage: wasmbrowsertest [flags] <wasm file>", "-wbt.headless=off", "-window-size", "-timeout"} {
+		if !strings.Contains(output.String(), s) {
+			t.Errorf("usage does not contain %q:\n%s", s, output.String())
+		}
+	}
+
+	output.Reset()
+	_, err = c.parseRunnerFlags([]string{"wasmbrowsertest", "-headles=off", "pkg.test"}, &output)
+	if err == nil || !strings.Contains(output.String(), "usage:") {
+		t.Errorf("expected an error and the usage, got %v and %q", err, output.String())
+	}
+
+	output.Reset()
+	_, err = c.parseRunnerFlags([]string{"wasmbrowsertest", "-headless=yes", "pkg.test"}, &output)
+	if err == nil || !strings.Contains(output.String(), "invalid headless mode") {
+		t.Errorf("expected an error about the headless mode, got %v and %q", err, output.String())
+	}
+}
+
+func TestCheckReservedFlags(t *testing.T) {
+	for _, tc := range []struct {
+		args      []string
+		expectErr string
+	}{
+		{args: nil},
+		{args: []string{"-test.v", "-test.run=TestWbt", "wbt.headless"}},
+		{args: []string{"-test.v", "--", "-wbt.headless=off"}},
+		{args: []string{"-test.v", "-wbt.headles=off"}, expectErr: "-wbt.headles"},
+		{args: []string{"--wbt.timeout", "1m"}, expectErr: "-wbt.timeout"},
+	} {
+		err := checkReservedFlags(tc.args)
+		if tc.expectErr == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", tc.args, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+			t.Errorf("%v: got %v, expected an error about %s", tc.args, err, tc.expectErr)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/rand"
 	_ "embed"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -12,9 +11,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
+	"text/template/parse"
 	"time"
 
 	"github.com/agnivade/wasmbrowsertest/filesys"
@@ -23,23 +22,58 @@ import (
 //go:embed index.html
 var indexHTML string
 
+//go:embed harness.html
+var harnessHTML string
+
+// indexData is passed to the index template, which may be a custom one.
+type indexData struct {
+	WASMFile      string
+	Args          []string
+	EnvMap        map[string]string
+	SecurityToken string
+	Pid           int
+	Ppid          int
+	Cwd           string
+	Preloads      []string
+	// Nonce is allowed by the csp security profile, inline scripts of a
+	// custom template need it too.
+	Nonce string
+	// TinyGo is set if TinyGo built the wasm file, its wasm_exec.js exits
+	// differently.
+	TinyGo bool
+}
+
 type wasmServer struct {
 	indexTmpl     *template.Template
 	wasmFile      string
 	wasmExecJS    []byte
+	tinygo        bool
 	args          []string
 	envMap        map[string]string
 	logger        *log.Logger
 	fsHandler     *filesys.Handler
 	securityToken string
-}
 
-var wasmLocations = []string{
-	"misc/wasm/wasm_exec.js",
-	"lib/wasm/wasm_exec.js",
+	fsTracer    *filesys.Tracer
+	fsTraceFile *os.File
+
+	// preloads are the JavaScript files loaded before the wasm binary runs.
+	preloads []string
+
+	// routes serve the paths the runner does not serve itself, like the
+	// static directories.
+	routes []route
+
+	// headerRules are the configured response headers, and nonce is the
+	// per-run nonce of the harness scripts.
+	headerRules []headerRule
+	nonce       string
 }
 
-func NewWASMServer(wasmFile string, args []string, coverageFile string, l *log.Logger) (http.Handler, error) {
+// preloadPath is where the preloaded files are served, by their index.
+const preloadPath = "/preload/"
+
+func NewWASMServer(wasmFile string, args []string, coverageFile string, l *log.Logger) (*wasmServer, error) {
 	var err error
 	srv := &wasmServer{
 		wasmFile: wasmFile,
@@ -55,59 +89,98 @@ func NewWASMServer(wasmFile string, args []string, coverageFile string, l *log.L
 		return nil, err
 	}
 	srv.fsHandler = filesys.NewHandler(srv.securityToken, l)
+	srv.nonce, err = generateNonce()
+	if err != nil {
+		return nil, err
+	}
 
-	for _, env := range os.Environ() {
-		vars := strings.SplitN(env, "=", 2)
-		srv.envMap[vars[0]] = vars[1]
+	srv.indexTmpl, err = parseIndexTemplate("index", indexHTML)
+	if err != nil {
+		return nil, err
 	}
+	return srv, nil
+}
 
-	var buf []byte
-	for _, loc := range wasmLocations {
-		buf, err = os.ReadFile(filepath.Join(runtime.GOROOT(), loc))
-		if err == nil {
-			break
-		}
-		if !os.IsNotExist(err) {
-			return nil, err
-		}
+// setIndexTemplate serves the template at path in place of the default
+// index.html. It has to include the "harness" template, which runs the
+// wasm binary.
+func (ws *wasmServer) setIndexTemplate(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading index template: %w", err)
 	}
+	tmpl, err := parseIndexTemplate(filepath.Base(path), string(buf))
 	if err != nil {
-		var perr *os.PathError
-		if errors.As(err, &perr) {
-			if strings.Contains(perr.Path, filepath.Join("golang.org", "toolchain")) {
-				return nil, fmt.Errorf("The Go toolchain does not include the WebAssembly exec helper before Go 1.24. Please copy wasm_exec.js to %s", filepath.Join(runtime.GOROOT(), "misc", "wasm", "wasm_exec.js"))
-			}
-		}
-		return nil, err
+		return fmt.Errorf("error parsing index template: %w", err)
+	}
+	if !includesTemplate(tmpl, tmpl.Tree.Root, "harness", map[string]bool{tmpl.Name(): true}) {
+		return fmt.Errorf(`index template %s does not include the harness, add {{template "harness" .}} to its body`, path)
 	}
-	srv.wasmExecJS = buf
+	ws.indexTmpl = tmpl
+	return nil
+}
 
-	srv.indexTmpl, err = template.New("index").Parse(indexHTML)
+// parseIndexTemplate parses text as an index page, which can refer to the
+// templates defined by harness.html.
+func parseIndexTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(harnessHTML)
 	if err != nil {
 		return nil, err
 	}
-	return srv, nil
+	return tmpl.Parse(text)
+}
+
+// includesTemplate reports whether the tree below node invokes the template
+// name, directly or through the templates of tmpl it invokes. visited are
+// the templates already walked, which guards against recursive templates.
+func includesTemplate(tmpl *template.Template, node parse.Node, name string, visited map[string]bool) bool {
+	switch n := node.(type) {
+	case *parse.TemplateNode:
+		if n.Name == name {
+			return true
+		}
+		if visited[n.Name] {
+			return false
+		}
+		visited[n.Name] = true
+		t := tmpl.Lookup(n.Name)
+		return t != nil && t.Tree != nil && includesTemplate(tmpl, t.Tree.Root, name, visited)
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if includesTemplate(tmpl, child, name, visited) {
+				return true
+			}
+		}
+	case *parse.IfNode:
+		return includesTemplate(tmpl, n.List, name, visited) || includesTemplate(tmpl, n.ElseList, name, visited)
+	case *parse.RangeNode:
+		return includesTemplate(tmpl, n.List, name, visited) || includesTemplate(tmpl, n.ElseList, name, visited)
+	case *parse.WithNode:
+		return includesTemplate(tmpl, n.List, name, visited) || includesTemplate(tmpl, n.ElseList, name, visited)
+	}
+	return false
 }
 
 func (ws *wasmServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// log.Println(r.URL.Path)
+	ws.applyHeaders(w, r.URL.Path)
 	switch r.URL.Path {
 	case "/", "/index.html":
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-		data := struct {
-			WASMFile      string
-			Args          []string
-			EnvMap        map[string]string
-			SecurityToken string
-			Pid           int
-			Ppid          int
-		}{
+		data := indexData{
 			WASMFile:      filepath.Base(ws.wasmFile),
 			Args:          ws.args,
 			EnvMap:        ws.envMap,
 			SecurityToken: ws.securityToken,
 			Pid:           os.Getpid(),
 			Ppid:          os.Getppid(),
+			Cwd:           ws.fsHandler.Cwd(),
+			Preloads:      ws.preloadURLs(),
+			Nonce:         ws.nonce,
+			TinyGo:        ws.tinygo,
 		}
 		err := ws.indexTmpl.Execute(w, data)
 		if err != nil {
@@ -135,10 +208,98 @@ func (ws *wasmServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	default:
 		if strings.HasPrefix(r.URL.Path, "/fs/") {
 			ws.fsHandler.ServeHTTP(w, r)
+		} else if strings.HasPrefix(r.URL.Path, preloadPath) {
+			ws.servePreload(w, r)
+		} else {
+			ws.serveRoute(w, r)
 		}
 	}
 }
 
+// setPreloads makes the page import the JavaScript files at paths, in order,
+// before the wasm binary runs.
+func (ws *wasmServer) setPreloads(paths []string) error {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("error preloading JavaScript: %w", err)
+		}
+	}
+	ws.preloads = paths
+	return nil
+}
+
+// preloadURLs returns the URLs the preloaded files are served at. The index
+// keeps files with the same name apart, the name makes errors readable.
+func (ws *wasmServer) preloadURLs() []string {
+	urls := make([]string, len(ws.preloads))
+	for i, path := range ws.preloads {
+		urls[i] = preloadPath + strconv.Itoa(i) + "/" + filepath.Base(path)
+	}
+	return urls
+}
+
+func (ws *wasmServer) servePreload(w http.ResponseWriter, r *http.Request) {
+	index, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, preloadPath), "/")
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(ws.preloads) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(ws.preloads[i])
+	if err != nil {
+		ws.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	// Modules are only run when served with a JavaScript MIME type,
+	// which the system's table may not know for .mjs files.
+	w.Header().Set("Content-Type", "text/javascript")
+	http.ServeContent(w, r, r.URL.Path, time.Time{}, f)
+}
+
+// traceFS writes a trace of every fs api call to the file at path.
+func (ws *wasmServer) traceFS(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating fs trace: %w", err)
+	}
+	ws.fsTraceFile = f
+	ws.fsTracer = filesys.NewTracer(f)
+	ws.fsHandler.SetTracer(ws.fsTracer)
+	return nil
+}
+
+// Close ends the session of the fs api, closing the files the program
+// left open and reporting them, and finishes the fs trace.
+func (ws *wasmServer) Close() {
+	leaked := ws.fsHandler.CloseFiles()
+	if len(leaked) > 0 {
+		ws.logger.Printf("program left %d file(s) open:", len(leaked))
+		for _, l := range leaked {
+			ws.logger.Printf("  %s", l)
+		}
+	}
+
+	if ws.fsTracer != nil {
+		if err := ws.fsTracer.WriteSummary(); err != nil {
+			ws.logger.Println(err)
+		}
+		if err := ws.fsTraceFile.Close(); err != nil {
+			ws.logger.Println(err)
+		}
+	}
+}
+
+// generateNonce returns a nonce which needs no escaping in HTML attributes.
+func generateNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
 func generateToken() (string, error) {
 	buf := make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
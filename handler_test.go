@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndexTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		template    string
+		expectErr   string
+		expectBody  []string
+	}{
+		{
+			description: "default",
+			expectBody: []string{
+				"<title>Go wasm</title>",
+				`<script src="wasm_exec.js" nonce="`,
+				`<button id="doneButton"`,
+			},
+		},
+		{
+			description: "custom template",
+			template: `<html><head><link rel="stylesheet" href="app.css"></head>
+<body><div id="app">{{.WASMFile}}</div>{{template "harness" .}}</body></html>`,
+			expectBody: []string{
+				`<link rel="stylesheet" href="app.css">`,
+				`<div id="app">test.wasm</div>`,
+				`<script src="wasm_exec.js" nonce="`,
+				`<button id="doneButton"`,
+			},
+		},
+		{
+			description: "custom template with harness in a conditional",
+			template:    `<html><body>{{if .Args}}{{template "harness" .}}{{end}}</body></html>`,
+			expectBody:  []string{`<button id="doneButton"`},
+		},
+		{
+			description: "custom template with harness in its own template",
+			template:    `{{define "body"}}<body>{{template "harness" .}}</body>{{end}}<html>{{template "body" .}}</html>`,
+			expectBody:  []string{`<body>`, `<button id="doneButton"`},
+		},
+		{
+			description: "custom template with recursive templates",
+			template:    `{{define "loop"}}{{if false}}{{template "loop" .}}{{end}}{{end}}<html>{{template "loop" .}}</html>`,
+			expectErr:   "does not include the harness",
+		},
+		{
+			description: "custom template without harness",
+			template:    `<html><body><div id="app"></div></body></html>`,
+			expectErr:   "does not include the harness",
+		},
+		{
+			description: "invalid custom template",
+			template:    `<html><body>{{template "harness" .}</body></html>`,
+			expectErr:   "error parsing index template",
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			srv := newTestWASMServer(t)
+			if tc.template != "" {
+				path := filepath.Join(t.TempDir(), "index.html")
+				writeFile(t, filepath.Dir(path), "index.html", tc.template)
+				err := srv.setIndexTemplate(path)
+				if tc.expectErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+						t.Fatalf("expected error containing %q, got %v", tc.expectErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			body := serveTest(t, srv, "/")
+			for _, expected := range tc.expectBody {
+				if !strings.Contains(body, expected) {
+					t.Errorf("index does not contain %q:\n%s", expected, body)
+				}
+			}
+		})
+	}
+}
+
+// newTestWASMServer returns a server for testdata/test.wasm with a
+// small environment, so that rendered pages stay readable.
+func newTestWASMServer(t *testing.T) *wasmServer {
+	t.Helper()
+	srv, err := NewWASMServer("testdata/test.wasm", []string{"test.wasm", "-test.v"}, "", log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.envMap = map[string]string{"HOME": "/home/gopher"}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func serveTest(t *testing.T, h http.Handler, path string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d", path, w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestPreload(t *testing.T) {
+	srv := newTestWASMServer(t)
+	dir := t.TempDir()
+	writeFile(t, dir, "shim.js", `globalThis.myLib = {};`)
+	writeFile(t, dir, "sub/shim.js", `export default function(go) {}`)
+	writeFile(t, dir, "hook.mjs", `export default function(go) { go.importObject.env = {}; }`)
+
+	err := srv.setPreloads([]string{filepath.Join(dir, "missing.js")})
+	if err == nil || !strings.Contains(err.Error(), "error preloading JavaScript") {
+		t.Fatalf("expected error for a missing file, got %v", err)
+	}
+	err = srv.setPreloads([]string{
+		filepath.Join(dir, "shim.js"),
+		filepath.Join(dir, "sub/shim.js"),
+		filepath.Join(dir, "hook.mjs"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := serveTest(t, srv, "/")
+	for _, expected := range []string{`"\/preload\/0\/shim.js"`, `"\/preload\/1\/shim.js"`, `"\/preload\/2\/hook.mjs"`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("index does not preload %s:\n%s", expected, body)
+		}
+	}
+
+	for path, content := range map[string]string{
+		"/preload/0/shim.js":  `globalThis.myLib = {};`,
+		"/preload/1/shim.js":  `export default function(go) {}`,
+		"/preload/2/hook.mjs": `export default function(go) { go.importObject.env = {}; }`,
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != content {
+			t.Errorf("GET %s: status %d, body %q", path, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/javascript" {
+			t.Errorf("GET %s: content type %q", path, ct)
+		}
+	}
+
+	for _, path := range []string{"/preload/3/hook.mjs", "/preload/x/shim.js", "/preload/-1/shim.js"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, expected 404", path, w.Code)
+		}
+	}
+}
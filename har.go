@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// The HAR 1.2 format, see http://www.softwareishard.com/blog/har-12-spec/.
+// Only what the browser reports is filled in.
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	// Error is why the request failed, as a custom field.
+	Error string `json:"_error,omitempty"`
+
+	// start is the monotonic time the request started, in seconds.
+	start  float64
+	timing *network.ResourceTiming
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int64          `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// Comment is "base64" if Text is base64 encoded, postData has no
+	// encoding field.
+	Comment string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// harBodyTimeout is how long the recorder waits for response bodies which
+// are still being fetched when the run ends.
+const harBodyTimeout = 5 * time.Second
+
+// harRecorder builds a HAR file from the Network domain events of the page.
+// The calls of the fs api are left out, unless includeFS is set.
+type harRecorder struct {
+	serverURL string
+	includeFS bool
+	fetchBody func(network.RequestID) ([]byte, error)
+
+	mu      sync.Mutex
+	entries []*harEntry
+	// pending are the entries of requests which are not finished yet.
+	pending map[network.RequestID]*harEntry
+	bodies  sync.WaitGroup
+}
+
+func newHARRecorder(serverURL string, includeFS bool, fetchBody func(network.RequestID) ([]byte, error)) *harRecorder {
+	return &harRecorder{
+		serverURL: serverURL,
+		includeFS: includeFS,
+		fetchBody: fetchBody,
+		pending:   make(map[network.RequestID]*harEntry),
+	}
+}
+
+// browserBodies fetches response bodies from the browser of ctx.
+func browserBodies(ctx context.Context) func(network.RequestID) ([]byte, error) {
+	return func(id network.RequestID) ([]byte, error) {
+		c := chromedp.FromContext(ctx)
+		return network.GetResponseBody(id).Do(cdp.WithExecutor(ctx, c.Target))
+	}
+}
+
+// handleEvent records the network events. It is called from the event
+// listener, so bodies are fetched in their own goroutine.
+func (hr *harRecorder) handleEvent(ev interface{}) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		if !hr.includeFS && strings.HasPrefix(ev.Request.URL, hr.serverURL+"/fs/") {
+			return
+		}
+		// A redirect reuses the request id, the redirect ends the previous entry.
+		if prev, ok := hr.pending[ev.RequestID]; ok && ev.RedirectResponse != nil {
+			prev.setResponse(ev.RedirectResponse)
+			prev.finish(monotonicSeconds(ev.Timestamp))
+		}
+		e := newHAREntry(ev)
+		hr.entries = append(hr.entries, e)
+		hr.pending[ev.RequestID] = e
+	case *network.EventResponseReceived:
+		if e, ok := hr.pending[ev.RequestID]; ok {
+			e.setResponse(ev.Response)
+		}
+	case *network.EventLoadingFinished:
+		e, ok := hr.pending[ev.RequestID]
+		if !ok {
+			return
+		}
+		delete(hr.pending, ev.RequestID)
+		e.Response.BodySize = int(ev.EncodedDataLength)
+		e.finish(monotonicSeconds(ev.Timestamp))
+		hr.bodies.Add(1)
+		go func() {
+			defer hr.bodies.Done()
+			body, err := hr.fetchBody(ev.RequestID)
+			if err != nil {
+				// Some responses, like redirects, have no body.
+				return
+			}
+			hr.mu.Lock()
+			defer hr.mu.Unlock()
+			e.Response.Content.Size = len(body)
+			if utf8.Valid(body) {
+				e.Response.Content.Text = string(body)
+			} else {
+				e.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+				e.Response.Content.Encoding = "base64"
+			}
+		}()
+	case *network.EventLoadingFailed:
+		e, ok := hr.pending[ev.RequestID]
+		if !ok {
+			return
+		}
+		delete(hr.pending, ev.RequestID)
+		e.Error = ev.ErrorText
+		e.finish(monotonicSeconds(ev.Timestamp))
+	}
+}
+
+func newHAREntry(ev *network.EventRequestWillBeSent) *harEntry {
+	e := &harEntry{
+		start: monotonicSeconds(ev.Timestamp),
+		Request: harRequest{
+			Method:      ev.Request.Method,
+			URL:         ev.Request.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(ev.Request.Headers),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+		},
+		Response: harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+		},
+		Timings: harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+	}
+	if ev.WallTime != nil {
+		e.StartedDateTime = ev.WallTime.Time().UTC().Format(time.RFC3339Nano)
+	}
+	if u, err := url.Parse(ev.Request.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				e.Request.QueryString = append(e.Request.QueryString, harNameValue{Name: name, Value: value})
+			}
+		}
+		sortNameValues(e.Request.QueryString)
+	}
+	if ev.Request.HasPostData {
+		var data []byte
+		for _, entry := range ev.Request.PostDataEntries {
+			b, _ := base64.StdEncoding.DecodeString(entry.Bytes)
+			data = append(data, b...)
+		}
+		e.Request.BodySize = len(data)
+		e.Request.PostData = &harPostData{MimeType: headerValue(ev.Request.Headers, "Content-Type")}
+		// Like the writes of the fs api, bodies may be binary.
+		if utf8.Valid(data) {
+			e.Request.PostData.Text = string(data)
+		} else {
+			e.Request.PostData.Text = base64.StdEncoding.EncodeToString(data)
+			e.Request.PostData.Comment = "base64"
+		}
+	}
+	return e
+}
+
+func (e *harEntry) setResponse(r *network.Response) {
+	e.Response.Status = r.Status
+	e.Response.StatusText = r.StatusText
+	e.Response.HTTPVersion = strings.ToUpper(r.Protocol)
+	if e.Response.HTTPVersion == "" {
+		e.Response.HTTPVersion = "HTTP/1.1"
+	}
+	e.Response.Headers = harHeaders(r.Headers)
+	e.Response.RedirectURL = headerValue(r.Headers, "Location")
+	e.Response.Content.MimeType = r.MimeType
+	e.ServerIPAddress = r.RemoteIPAddress
+	e.timing = r.Timing
+}
+
+// finish sets the duration of the entry. The browser times the request
+// phases relative to the start of the request, in milliseconds.
+func (e *harEntry) finish(end float64) {
+	total := roundMillis(max(0, (end-e.start)*1000))
+	if t := e.timing; t != nil && t.SendStart >= 0 && t.ReceiveHeadersEnd >= t.SendEnd {
+		e.Timings.Blocked = roundMillis(t.SendStart)
+		e.Timings.Send = roundMillis(t.SendEnd - t.SendStart)
+		e.Timings.Wait = roundMillis(t.ReceiveHeadersEnd - t.SendEnd)
+		e.Timings.Receive = roundMillis(max(0, (end-t.RequestTime)*1000-t.ReceiveHeadersEnd))
+		total = e.Timings.Blocked + e.Timings.Send + e.Timings.Wait + e.Timings.Receive
+	} else {
+		e.Timings.Wait = total
+	}
+	e.Time = total
+}
+
+// roundMillis rounds to microseconds, which is as precise as the browser is.
+func roundMillis(ms float64) float64 {
+	return math.Round(ms*1000) / 1000
+}
+
+func monotonicSeconds(t *cdp.MonotonicTime) float64 {
+	if t == nil {
+		return 0
+	}
+	return float64(t.Time().UnixNano()) / float64(time.Second)
+}
+
+// harHeaders returns the headers, with the security token of the fs api
+// redacted.
+func harHeaders(headers network.Headers) []harNameValue {
+	nvs := make([]harNameValue, 0, len(headers))
+	for name, value := range headers {
+		nv := harNameValue{Name: name, Value: fmt.Sprint(value)}
+		if strings.EqualFold(name, "WBT-Token") {
+			nv.Value = redactedValue
+		}
+		nvs = append(nvs, nv)
+	}
+	sortNameValues(nvs)
+	return nvs
+}
+
+func sortNameValues(nvs []harNameValue) {
+	sort.Slice(nvs, func(i, j int) bool {
+		if nvs[i].Name != nvs[j].Name {
+			return nvs[i].Name < nvs[j].Name
+		}
+		return nvs[i].Value < nvs[j].Value
+	})
+}
+
+// writeFile writes the recorded entries to path, once the pending bodies
+// are fetched. Requests which never finished are written as they are.
+func (hr *harRecorder) writeFile(path string) error {
+	done := make(chan struct{})
+	go func() {
+		hr.bodies.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(harBodyTimeout):
+	}
+
+	hr.mu.Lock()
+	har := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "wasmbrowsertest", Version: buildVersion()},
+		Entries: hr.entries,
+	}}
+	if har.Log.Entries == nil {
+		har.Log.Entries = []*harEntry{}
+	}
+	buf, err := json.MarshalIndent(har, "", "  ")
+	hr.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		return fmt.Errorf("error writing HAR file: %w", err)
+	}
+	return nil
+}
+
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "devel"
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestHARRecorder(t *testing.T) {
+	const server = "http://127.0.0.1:4321"
+	at := func(ms int) *cdp.MonotonicTime {
+		mt := cdp.MonotonicTime(time.Unix(100, 0).Add(time.Duration(ms) * time.Millisecond))
+		return &mt
+	}
+	wall := cdp.TimeSinceEpoch(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	bodies := map[network.RequestID][]byte{
+		"1": []byte(`{"ok":true}`),
+		"2": {0xff, 0x00},
+	}
+	hr := newHARRecorder(server, false, func(id network.RequestID) ([]byte, error) {
+		if body, ok := bodies[id]; ok {
+			return body, nil
+		}
+		return nil, errors.New("no body")
+	})
+
+	for _, ev := range []interface{}{
+		&network.EventRequestWillBeSent{RequestID: "1", Timestamp: at(0), WallTime: &wall, Request: &network.Request{
+			Method: "POST", URL: server + "/api/users?b=2&a=1",
+			Headers:     network.Headers{"Content-Type": "application/json"},
+			HasPostData: true, PostDataEntries: []*network.PostDataEntry{{Bytes: "eyJuYW1lIjoi"}, {Bytes: "Z29waGVyIn0="}},
+		}},
+		&network.EventRequestWillBeSent{RequestID: "fs", Timestamp: at(1), WallTime: &wall, Request: &network.Request{
+			Method: "POST", URL: server + "/fs/stat",
+		}},
+		&network.EventResponseReceived{RequestID: "1", Response: &network.Response{
+			Status: 201, StatusText: "Created", Protocol: "http/1.1", MimeType: "application/json",
+			Headers: network.Headers{"Content-Type": "application/json"}, RemoteIPAddress: "127.0.0.1",
+			Timing: &network.ResourceTiming{RequestTime: 100, SendStart: 2, SendEnd: 3, ReceiveHeadersEnd: 13},
+		}},
+		&network.EventRequestWillBeSent{RequestID: "2", Timestamp: at(5), WallTime: &wall, Request: &network.Request{
+			Method: "GET", URL: "https://example.com/old",
+		}},
+		&network.EventRequestWillBeSent{RequestID: "2", Timestamp: at(8), WallTime: &wall, Request: &network.Request{
+			Method: "GET", URL: "https://example.com/logo.png",
+		}, RedirectResponse: &network.Response{Status: 301, StatusText: "Moved Permanently", Headers: network.Headers{"Location": "/logo.png"}}},
+		&network.EventResponseReceived{RequestID: "2", Response: &network.Response{Status: 200, MimeType: "image/png"}},
+		&network.EventLoadingFinished{RequestID: "1", Timestamp: at(20), EncodedDataLength: 11},
+		&network.EventLoadingFinished{RequestID: "2", Timestamp: at(10), EncodedDataLength: 2},
+		&network.EventRequestWillBeSent{RequestID: "3", Timestamp: at(11), WallTime: &wall, Request: &network.Request{
+			Method: "GET", URL: "https://offline.example.com/",
+		}},
+		&network.EventLoadingFailed{RequestID: "3", Timestamp: at(12), ErrorText: "net::ERR_CONNECTION_REFUSED"},
+	} {
+		hr.handleEvent(ev)
+	}
+
+	path := filepath.Join(t.TempDir(), "run.har")
+	if err := hr.writeFile(path); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var har harFile
+	if err := json.Unmarshal(buf, &har); err != nil {
+		t.Fatal(err)
+	}
+	if har.Log.Version != "1.2" || har.Log.Creator.Name != "wasmbrowsertest" || len(har.Log.Entries) != 4 {
+		t.Fatalf("unexpected HAR:\n%s", buf)
+	}
+
+	post := har.Log.Entries[0]
+	if post.StartedDateTime != "2024-01-02T03:04:05Z" || post.Request.Method != "POST" || post.Response.Status != 201 {
+		t.Errorf("unexpected entry %+v", post)
+	}
+	if post.Request.PostData == nil || post.Request.PostData.Text != `{"name":"gopher"}` || post.Request.PostData.MimeType != "application/json" {
+		t.Errorf("unexpected post data %+v", post.Request.PostData)
+	}
+	if q := post.Request.QueryString; len(q) != 2 || q[0] != (harNameValue{"a", "1"}) || q[1] != (harNameValue{"b", "2"}) {
+		t.Errorf("unexpected query string %v", q)
+	}
+	if post.Response.Content.Text != `{"ok":true}` || post.Response.Content.Size != 11 || post.Response.BodySize != 11 {
+		t.Errorf("unexpected content %+v", post.Response.Content)
+	}
+	if tm := post.Timings; tm.Blocked != 2 || tm.Send != 1 || tm.Wait != 10 || tm.Receive != 7 || post.Time != 20 {
+		t.Errorf("unexpected timings %+v, time %v", tm, post.Time)
+	}
+
+	redirect, png, failed := har.Log.Entries[1], har.Log.Entries[2], har.Log.Entries[3]
+	if redirect.Response.Status != 301 || redirect.Response.RedirectURL != "/logo.png" || redirect.Time != 3 {
+		t.Errorf("unexpected redirect entry %+v", redirect)
+	}
+	if png.Request.URL != "https://example.com/logo.png" || png.Response.Content.Encoding != "base64" || png.Response.Content.Text != "/wA=" {
+		t.Errorf("unexpected png entry %+v", png)
+	}
+	if failed.Error != "net::ERR_CONNECTION_REFUSED" || failed.Response.Status != 0 {
+		t.Errorf("unexpected failed entry %+v", failed)
+	}
+}
+
+func TestHARRecorder_fs(t *testing.T) {
+	hr := newHARRecorder("http://127.0.0.1:4321", true, nil)
+	hr.handleEvent(&network.EventRequestWillBeSent{RequestID: "fs", Request: &network.Request{
+		Method: "POST", URL: "http://127.0.0.1:4321/fs/write?fd=3",
+		Headers:     network.Headers{"Content-Type": "application/octet-stream", "WBT-Token": "secret"},
+		HasPostData: true, PostDataEntries: []*network.PostDataEntry{{Bytes: "AP8="}},
+	}})
+	if len(hr.entries) != 1 {
+		t.Fatalf("fs call was not recorded")
+	}
+	req := hr.entries[0].Request
+	if req.PostData == nil || req.PostData.Text != "AP8=" || req.PostData.Comment != "base64" || req.BodySize != 2 {
+		t.Errorf("unexpected post data %+v", req.PostData)
+	}
+	for _, h := range req.Headers {
+		if h.Name == "WBT-Token" && h.Value != redactedValue {
+			t.Errorf("the security token is recorded as %q", h.Value)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// headerRule sets headers on the responses below prefix. An empty value
+// removes the header.
+type headerRule struct {
+	prefix string
+	header map[string]string
+}
+
+// securityProfiles are named sets of headers, which take the nonce of the
+// harness scripts.
+var securityProfiles = map[string]func(nonce string) map[string]string{
+	// coi makes the page cross-origin isolated, which SharedArrayBuffer needs.
+	"coi": func(string) map[string]string {
+		return map[string]string{
+			"Cross-Origin-Opener-Policy":   "same-origin",
+			"Cross-Origin-Embedder-Policy": "require-corp",
+			"Cross-Origin-Resource-Policy": "same-origin",
+		}
+	},
+	// csp is a strict Content-Security-Policy without unsafe-inline or
+	// unsafe-eval. Only the harness scripts carry the nonce.
+	"csp": func(nonce string) map[string]string {
+		return map[string]string{
+			"Content-Security-Policy": "default-src 'self'; " +
+				"script-src 'self' 'nonce-" + nonce + "' 'wasm-unsafe-eval'; " +
+				"object-src 'none'; base-uri 'none'",
+		}
+	},
+}
+
+// setSecurityProfiles sets the headers of the named profiles on every response.
+func (ws *wasmServer) setSecurityProfiles(names []string) error {
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		profile, ok := securityProfiles[name]
+		if !ok {
+			return fmt.Errorf("unknown security profile %q, expected one of coi, csp", name)
+		}
+		ws.addHeaders("/", profile(ws.nonce))
+	}
+	return nil
+}
+
+// readHeadersFile sets the headers of a JSON file, which maps URL path
+// prefixes to headers:
+//
+//	{"/": {"Cross-Origin-Opener-Policy": "same-origin"}, "/testdata/": {"Cache-Control": "max-age=60"}}
+func (ws *wasmServer) readHeadersFile(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading headers file: %w", err)
+	}
+	var rules map[string]map[string]string
+	if err := json.Unmarshal(buf, &rules); err != nil {
+		return fmt.Errorf("error parsing headers file %s: %w", path, err)
+	}
+	for prefix, header := range rules {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("error parsing headers file %s: path %q does not start with /", path, prefix)
+		}
+		ws.addHeaders(prefix, header)
+	}
+	return nil
+}
+
+// addHeaders sets header on the responses below prefix. Rules for longer
+// prefixes are applied last, so the most specific value wins. Rules for
+// the same prefix are applied in the order they were added.
+func (ws *wasmServer) addHeaders(prefix string, header map[string]string) {
+	ws.headerRules = append(ws.headerRules, headerRule{prefix: prefix, header: header})
+	sort.SliceStable(ws.headerRules, func(i, j int) bool {
+		return len(ws.headerRules[i].prefix) < len(ws.headerRules[j].prefix)
+	})
+}
+
+// applyHeaders sets the configured headers for path on w, before the
+// response is written.
+func (ws *wasmServer) applyHeaders(w http.ResponseWriter, path string) {
+	for _, rule := range ws.headerRules {
+		if !strings.HasPrefix(path, rule.prefix) {
+			continue
+		}
+		for key, value := range rule.header {
+			if value == "" {
+				w.Header().Del(key)
+			} else {
+				w.Header().Set(key, value)
+			}
+		}
+	}
+}
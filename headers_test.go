@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecurityProfiles(t *testing.T) {
+	srv := newTestWASMServer(t)
+	if err := srv.setSecurityProfiles([]string{"coi", "sandbox"}); err == nil || !strings.Contains(err.Error(), "unknown security profile") {
+		t.Fatalf("expected error for an unknown profile, got %v", err)
+	}
+	srv.headerRules = nil
+	if err := srv.setSecurityProfiles([]string{"coi", " csp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	for key, expected := range map[string]string{
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Embedder-Policy": "require-corp",
+		"Cross-Origin-Resource-Policy": "same-origin",
+	} {
+		if value := w.Header().Get(key); value != expected {
+			t.Errorf("%s is %q, expected %q", key, value, expected)
+		}
+	}
+	csp := w.Header().Get("Content-Security-Policy")
+	if strings.Contains(csp, "unsafe-inline") || !strings.Contains(csp, "'nonce-"+srv.nonce+"'") {
+		t.Errorf("unexpected Content-Security-Policy %q", csp)
+	}
+	// Every script of the harness carries the nonce, and nothing relies on
+	// inline styles.
+	body := w.Body.String()
+	if scripts, nonces := strings.Count(body, "<script"), strings.Count(body, `nonce="`+srv.nonce+`"`); scripts != nonces {
+		t.Errorf("%d scripts, but %d nonces:\n%s", scripts, nonces, body)
+	}
+	if strings.Contains(body, "style=") {
+		t.Errorf("index uses an inline style:\n%s", body)
+	}
+}
+
+func TestHeadersFile(t *testing.T) {
+	srv := newTestWASMServer(t)
+	dir := t.TempDir()
+	writeFile(t, dir, "data/fixture.json", `{}`)
+	writeFile(t, dir, "bad.json", `{"testdata": {"X-Test": "a"}}`)
+	writeFile(t, dir, "headers.json", `{
+		"/": {"X-Test": "root", "Cross-Origin-Embedder-Policy": "credentialless"},
+		"/testdata/": {"X-Test": "testdata", "Cache-Control": "max-age=60", "Cross-Origin-Resource-Policy": ""}
+	}`)
+	if err := srv.readHeadersFile(filepath.Join(dir, "bad.json")); err == nil || !strings.Contains(err.Error(), "does not start with /") {
+		t.Fatalf("expected error for a relative path, got %v", err)
+	}
+	srv.headerRules = nil
+	if err := srv.setSecurityProfiles([]string{"coi"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.readHeadersFile(filepath.Join(dir, "headers.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.addStaticDirs([]mount{{"/testdata/", filepath.Join(dir, "data")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path     string
+		expected map[string]string
+	}{
+		{"/", map[string]string{
+			"X-Test":                       "root",
+			"Cross-Origin-Embedder-Policy": "credentialless",
+			"Cross-Origin-Resource-Policy": "same-origin",
+		}},
+		{"/testdata/fixture.json", map[string]string{
+			"X-Test":                       "testdata",
+			"Cache-Control":                "max-age=60",
+			"Cross-Origin-Embedder-Policy": "credentialless",
+			"Cross-Origin-Resource-Policy": "",
+		}},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		for key, expected := range tc.expected {
+			if value := w.Header().Get(key); value != expected {
+				t.Errorf("GET %s: %s is %q, expected %q", tc.path, key, value, expected)
+			}
+		}
+	}
+}
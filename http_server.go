@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
 	"net"
 	"net/http"
@@ -9,12 +10,19 @@ import (
 	"time"
 )
 
-func startHTTPServer(ctx context.Context, handler http.Handler, logger *log.Logger) (url string, shutdown context.CancelFunc, err error) {
+// startHTTPServer serves handler on a random localhost port. It serves
+// HTTPS instead of HTTP when tlsConfig is not nil.
+func startHTTPServer(ctx context.Context, handler http.Handler, logger *log.Logger, tlsConfig *tls.Config) (url string, shutdown context.CancelFunc, err error) {
 	// Need to generate a random port every time for tests in parallel to run.
 	l, err := net.Listen("tcp", "localhost:")
 	if err != nil {
 		return "", nil, err
 	}
+	scheme := "http"
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+		scheme = "https"
+	}
 
 	server := &http.Server{
 		Handler: handler,
@@ -44,7 +52,7 @@ func startHTTPServer(ctx context.Context, handler http.Handler, logger *log.Logg
 		<-shutdownComplete
 	}
 	url = (&neturl.URL{
-		Scheme: "http",
+		Scheme: scheme,
 		Host:   l.Addr().String(),
 	}).String()
 	return url, shutdown, nil
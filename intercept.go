@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// interceptor answers the requests the browser pauses with the Fetch
+// domain, for the mocks and for the relaxed CORS origins. Requests to the
+// wasm server itself are only answered by mocks.
+type interceptor struct {
+	serverURL string
+	mocks     *mockSet
+	cors      corsAllowlist
+}
+
+// interception is how a paused request is resumed.
+type interception struct {
+	action  interceptAction
+	status  int
+	headers map[string]string
+	body    []byte
+	// reason is logged when a request is failed.
+	reason string
+}
+
+type interceptAction int
+
+const (
+	interceptContinue interceptAction = iota
+	interceptFulfill
+	interceptFail
+)
+
+// enable makes the browser pause the requests the interceptor answers.
+// Mocks need every request, CORS only the requests to its origins, and
+// their responses.
+func (ic *interceptor) enable() chromedp.Action {
+	var patterns []*fetch.RequestPattern
+	if ic.mocks != nil {
+		patterns = append(patterns, &fetch.RequestPattern{URLPattern: "*", RequestStage: fetch.RequestStageRequest})
+	}
+	for _, origin := range ic.cors {
+		if ic.mocks == nil {
+			patterns = append(patterns, &fetch.RequestPattern{URLPattern: origin + "/*", RequestStage: fetch.RequestStageRequest})
+		}
+		patterns = append(patterns, &fetch.RequestPattern{URLPattern: origin + "/*", RequestStage: fetch.RequestStageResponse})
+	}
+	return fetch.Enable().WithPatterns(patterns)
+}
+
+// intercept decides how to answer a request.
+func (ic *interceptor) intercept(method, url string, headers network.Headers) interception {
+	if ic.mocks != nil {
+		if in, ok := ic.mocks.mock(method, url, headers); ok {
+			return in
+		}
+	}
+	if ic.cors.allows(url) {
+		if isPreflight(method, headers) {
+			return interception{
+				action:  interceptFulfill,
+				status:  http.StatusNoContent,
+				headers: preflightHeaders(headerValue(headers, "Origin"), headers),
+			}
+		}
+		return interception{action: interceptContinue}
+	}
+	if ic.mocks != nil && !strings.HasPrefix(url, ic.serverURL+"/") {
+		return ic.mocks.unmatched(method, url)
+	}
+	return interception{action: interceptContinue}
+}
+
+// interceptResponse returns the headers a response continues with. The
+// responses of relaxed CORS origins allow the page's origin, whatever the
+// server sent.
+func (ic *interceptor) interceptResponse(url string, reqHeaders network.Headers, respHeaders []*fetch.HeaderEntry) []*fetch.HeaderEntry {
+	origin := headerValue(reqHeaders, "Origin")
+	if !ic.cors.allows(url) || origin == "" {
+		return nil
+	}
+	allow := allowOriginHeaders(origin)
+	var headers []*fetch.HeaderEntry
+	for _, h := range respHeaders {
+		if _, ok := allow[http.CanonicalHeaderKey(h.Name)]; !ok {
+			headers = append(headers, h)
+		}
+	}
+	return append(headers, headerEntries(allow)...)
+}
+
+// handleRequestPaused answers a paused request. Commands can not be sent from
+// the event listener, so it has to run in its own goroutine.
+func (ic *interceptor) handleRequestPaused(ctx context.Context, ev *fetch.EventRequestPaused, logger *log.Logger) {
+	c := chromedp.FromContext(ctx)
+	ctx = cdp.WithExecutor(ctx, c.Target)
+	var err error
+	if ev.ResponseStatusCode != 0 || ev.ResponseErrorReason != "" {
+		headers := ic.interceptResponse(ev.Request.URL, ev.Request.Headers, ev.ResponseHeaders)
+		if headers == nil {
+			err = fetch.ContinueResponse(ev.RequestID).Do(ctx)
+		} else {
+			err = fetch.ContinueResponse(ev.RequestID).WithResponseHeaders(headers).Do(ctx)
+		}
+	} else {
+		in := ic.intercept(ev.Request.Method, ev.Request.URL, ev.Request.Headers)
+		switch in.action {
+		case interceptContinue:
+			err = fetch.ContinueRequest(ev.RequestID).Do(ctx)
+		case interceptFail:
+			logger.Printf("%s for %s %s, failing the request", in.reason, ev.Request.Method, ev.Request.URL)
+			err = fetch.FailRequest(ev.RequestID, network.ErrorReasonConnectionRefused).Do(ctx)
+		case interceptFulfill:
+			err = fetch.FulfillRequest(ev.RequestID, int64(in.status)).
+				WithResponseHeaders(headerEntries(in.headers)).
+				WithBody(base64.StdEncoding.EncodeToString(in.body)).
+				Do(ctx)
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		logger.Printf("error answering %s %s: %v", ev.Request.Method, ev.Request.URL, err)
+	}
+}
+
+func isPreflight(method string, headers network.Headers) bool {
+	return method == http.MethodOptions && headerValue(headers, "Access-Control-Request-Method") != ""
+}
+
+// headerValue returns the request header name, which CDP reports with the
+// case the page used.
+func headerValue(headers network.Headers, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			s, _ := value.(string)
+			return s
+		}
+	}
+	return ""
+}
+
+// allowOriginHeaders allow origin to read a response, with credentials.
+func allowOriginHeaders(origin string) map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":      origin,
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Expose-Headers":    "*",
+		"Vary":                             "Origin",
+	}
+}
+
+// preflightHeaders allow the method and headers a preflight asks for.
+func preflightHeaders(origin string, headers network.Headers) map[string]string {
+	h := allowOriginHeaders(origin)
+	h["Access-Control-Allow-Methods"] = headerValue(headers, "Access-Control-Request-Method")
+	if requested := headerValue(headers, "Access-Control-Request-Headers"); requested != "" {
+		h["Access-Control-Allow-Headers"] = requested
+	}
+	h["Access-Control-Max-Age"] = "600"
+	return h
+}
+
+// headerEntries converts headers in a stable order.
+func headerEntries(headers map[string]string) []*fetch.HeaderEntry {
+	entries := make([]*fetch.HeaderEntry, 0, len(headers))
+	for name, value := range headers {
+		entries = append(entries, &fetch.HeaderEntry{Name: name, Value: value})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestInterceptor_CORS(t *testing.T) {
+	const server = "http://127.0.0.1:4321"
+	cors, err := parseCORSAllowlist("http://localhost:8080, https://127.0.0.1:9443/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic := &interceptor{serverURL: server, cors: cors}
+
+	preflight := ic.intercept("OPTIONS", "http://localhost:8080/api/users", network.Headers{
+		"Origin":                         server,
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type,x-token",
+	})
+	expected := interception{
+		action: interceptFulfill,
+		status: 204,
+		headers: map[string]string{
+			"Access-Control-Allow-Origin":      server,
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Expose-Headers":    "*",
+			"Access-Control-Allow-Methods":     "PUT",
+			"Access-Control-Allow-Headers":     "content-type,x-token",
+			"Access-Control-Max-Age":           "600",
+			"Vary":                             "Origin",
+		},
+	}
+	if !reflect.DeepEqual(preflight, expected) {
+		t.Errorf("preflight: got %+v, expected %+v", preflight, expected)
+	}
+	for _, url := range []string{"http://localhost:8080/api/users", "http://localhost:9090/", "https://example.com/"} {
+		if in := ic.intercept("PUT", url, network.Headers{"Origin": server}); in.action != interceptContinue {
+			t.Errorf("PUT %s: got %+v, expected the request to continue", url, in)
+		}
+	}
+	// Without mocks, a preflight to another origin is left to its server.
+	if in := ic.intercept("OPTIONS", "http://localhost:9090/", network.Headers{"Access-Control-Request-Method": "PUT"}); in.action != interceptContinue {
+		t.Errorf("preflight to another origin: got %+v", in)
+	}
+
+	respHeaders := []*fetch.HeaderEntry{
+		{Name: "content-type", Value: "application/json"},
+		{Name: "access-control-allow-origin", Value: "https://other.example.com"},
+	}
+	headers := ic.interceptResponse("https://127.0.0.1:9443/x", network.Headers{"Origin": server}, respHeaders)
+	expectedHeaders := []*fetch.HeaderEntry{
+		{Name: "content-type", Value: "application/json"},
+		{Name: "Access-Control-Allow-Credentials", Value: "true"},
+		{Name: "Access-Control-Allow-Origin", Value: server},
+		{Name: "Access-Control-Expose-Headers", Value: "*"},
+		{Name: "Vary", Value: "Origin"},
+	}
+	if !reflect.DeepEqual(headers, expectedHeaders) {
+		t.Errorf("response headers: got %v, expected %v", headers, expectedHeaders)
+	}
+	if headers := ic.interceptResponse("https://127.0.0.1:9443/x", nil, respHeaders); headers != nil {
+		t.Errorf("same-origin response was changed: %v", headers)
+	}
+	if headers := ic.interceptResponse("http://localhost:9090/x", network.Headers{"Origin": server}, respHeaders); headers != nil {
+		t.Errorf("response of another origin was changed: %v", headers)
+	}
+}
+
+func TestInterceptor_mocksAndCORS(t *testing.T) {
+	const server = "http://127.0.0.1:4321"
+	ms := &mockSet{Mocks: []*mockRule{{URL: "https://api.example.com/*", Status: 200, pattern: globPattern("https://api.example.com/*")}}}
+	ic := &interceptor{serverURL: server, mocks: ms, cors: corsAllowlist{"http://localhost:8080"}}
+
+	for _, tc := range []struct {
+		url    string
+		action interceptAction
+	}{
+		{"https://api.example.com/users", interceptFulfill},
+		{"http://localhost:8080/users", interceptContinue},
+		{server + "/test.wasm", interceptContinue},
+		{"https://example.com/", interceptFail},
+	} {
+		if in := ic.intercept("GET", tc.url, nil); in.action != tc.action {
+			t.Errorf("GET %s: got action %d, expected %d", tc.url, in.action, tc.action)
+		}
+	}
+}
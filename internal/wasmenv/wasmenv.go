@@ -0,0 +1,67 @@
+// Package wasmenv computes how much of the memory reserved for the command
+// line and the environment a js/wasm program takes, as wasm_exec.js lays
+// them out, and knows which variables Go programs commonly need.
+package wasmenv
+
+import "strings"
+
+// wasm_exec.js writes the arguments and the environment to the memory from
+// Start, and fails with "total length of command line and environment
+// variables exceeds limit" if they reach End, where the data of the
+// program starts.
+const (
+	Start = 4096
+	End   = 4096 + 8192
+	// Limit is the number of bytes available.
+	Limit = End - Start
+	// ArgsReserve is the room to leave for the arguments of a test binary,
+	// when only the environment is known.
+	ArgsReserve = 1024
+)
+
+// Size returns how many bytes s takes, with its pointer.
+func Size(s string) int {
+	n := len(s) + 1
+	return (n+7)&^7 + 8
+}
+
+// Total returns how many bytes args and env, KEY=VALUE pairs like
+// os.Environ returns, take. It fits if it is less than Limit.
+func Total(args, env []string) int {
+	// The two lists are terminated by a null pointer each.
+	total := 2 * 8
+	for _, arg := range args {
+		total += Size(arg)
+	}
+	for _, kv := range env {
+		total += Size(kv)
+	}
+	return total
+}
+
+// Common are the variables Go programs and tests commonly read, as names
+// or prefixes ending with *. The Go variables are listed by name, other
+// variables starting with GO, like GOOGLE_API_KEY, may hold secrets.
+var Common = []string{
+	"GO111MODULE", "GOARCH", "GOBIN", "GOCACHE", "GOCOVERDIR", "GODEBUG",
+	"GOENV", "GOEXPERIMENT", "GOFLAGS", "GOGC", "GOINSECURE", "GOMAXPROCS",
+	"GOMEMLIMIT", "GOMODCACHE", "GONOPROXY", "GONOSUMDB", "GOOS", "GOPATH",
+	"GOPRIVATE", "GOPROXY", "GOROOT", "GOSUMDB", "GOTMPDIR", "GOTOOLCHAIN",
+	"GOTRACEBACK", "GOWASM", "GOWORK",
+	"CGO_*", "HOME", "USER", "PATH", "PWD", "TMPDIR", "TMP", "TEMP",
+	"LANG", "LC_*", "TZ", "CI",
+}
+
+// IsCommon reports whether name is one of Common.
+func IsCommon(name string) bool {
+	for _, c := range Common {
+		if prefix, ok := strings.CutSuffix(c, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == c {
+			return true
+		}
+	}
+	return false
+}
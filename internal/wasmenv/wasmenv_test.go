@@ -0,0 +1,32 @@
+package wasmenv
+
+import "testing"
+
+func TestTotal(t *testing.T) {
+	// "a.wasm\0" takes 8 bytes, "-test.v\0" 8 and "HOME=/root\0" 16, plus
+	// 3 pointers and the 2 terminators.
+	total := Total([]string{"a.wasm", "-test.v"}, []string{"HOME=/root"})
+	if expected := 8 + 8 + 16 + 5*8; total != expected {
+		t.Errorf("got %d, expected %d", total, expected)
+	}
+	if total := Total(nil, nil); total != 16 {
+		t.Errorf("got %d for nothing, expected 16", total)
+	}
+}
+
+func TestIsCommon(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"GOFLAGS":     true,
+		"GO":          false,
+		"GOOGLE_KEY":  false,
+		"CGO_ENABLED": true,
+		"HOME":        true,
+		"HOMEPAGE":    false,
+		"LC_ALL":      true,
+		"GITHUB_SHA":  false,
+	} {
+		if IsCommon(name) != expected {
+			t.Errorf("IsCommon(%q) is %v, expected %v", name, !expected, expected)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
@@ -14,8 +16,11 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/chromedp/cdproto/fetch"
 	"github.com/chromedp/cdproto/inspector"
+	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/profiler"
 	cdpruntime "github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/cdproto/target"
@@ -41,10 +46,28 @@ func run(ctx context.Context, args []string, errOutput io.Writer, flagSet *flag.
 		}
 	}()
 
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	args, err = cfg.parseRunnerFlags(args, errOutput)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 	if len(args) < 2 {
 		return errors.New("Please pass a wasm file as a parameter")
 	}
-
+	cfg.registerFlags(flagSet, runnerFlagPrefix)
+	if isConfigCommand(args[1]) {
+		if _, err := gentleParse(flagSet, args[2:]); err != nil {
+			return err
+		}
+		fmt.Fprintln(errOutput, cfg.source())
+		return cfg.write(os.Stdout)
+	}
 	cpuProfile := flagSet.String("test.cpuprofile", "", "")
 	coverageProfile := flagSet.String("test.coverprofile", "", "")
 
@@ -61,10 +84,29 @@ func run(ctx context.Context, args []string, errOutput io.Writer, flagSet *flag.
 		args[1] = wasmFile
 	}
 
+	mod, err := readWasmModule(wasmFile)
+	if err != nil {
+		return err
+	}
+	features, err := mod.features()
+	if err != nil {
+		return fmt.Errorf("%s is not a valid WebAssembly module: %w", wasmFile, err)
+	}
+
 	passon, err := gentleParse(flagSet, args[2:])
 	if err != nil {
 		return err
 	}
+	if err := checkReservedFlags(passon); err != nil {
+		return err
+	}
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+		defer cancel()
+	}
+	runCtx := ctx
+
 	passon = append([]string{wasmFile}, passon...)
 	if *coverageProfile != "" {
 		passon = append(passon, "-test.coverprofile="+*coverageProfile)
@@ -75,16 +117,68 @@ func run(ctx context.Context, args []string, errOutput io.Writer, flagSet *flag.
 	if err != nil {
 		return err
 	}
-	url, shutdownHTTPServer, err := startHTTPServer(ctx, handler, logger)
+	defer handler.Close()
+	if err := handler.loadWasmExec(mod, cfg.Server); err != nil {
+		return err
+	}
+	handler.envMap, err = cfg.Env.filter(os.Environ())
+	if err != nil {
+		return err
+	}
+	if err := handler.configure(cfg.Server); err != nil {
+		return err
+	}
+	if cfg.Artifacts.FSTrace != "" {
+		if err := handler.traceFS(cfg.Artifacts.FSTrace); err != nil {
+			return err
+		}
+	}
+	var mocks *mockSet
+	if cfg.Server.Mocks != "" {
+		mocks, err = readMocksFile(cfg.Server.Mocks)
+		if err != nil {
+			return err
+		}
+	}
+	corsOrigins, err := parseCORSAllowlist(strings.Join(cfg.Server.CORSAllow, ","))
+	if err != nil {
+		return err
+	}
+	if cfg.Server.Sidecars != "" {
+		configs, err := readSidecarsFile(cfg.Server.Sidecars)
+		if err != nil {
+			return err
+		}
+		sidecars, err := startSidecars(ctx, configs, errOutput, logger)
+		if err != nil {
+			return err
+		}
+		defer sidecars.Close()
+		for key, value := range sidecars.env {
+			handler.envMap[key] = value
+		}
+	}
+	checkArgsSize(passon, handler.envMap, logger)
+	var certs *ephemeralCerts
+	var tlsConfig *tls.Config
+	if cfg.Server.HTTPS {
+		certs, err = newEphemeralCerts()
+		if err != nil {
+			return fmt.Errorf("error generating certificates: %w", err)
+		}
+		tlsConfig = certs.tlsConfig()
+	}
+	url, shutdownHTTPServer, err := startHTTPServer(ctx, handler, logger, tlsConfig)
 	if err != nil {
 		return err
 	}
 	defer shutdownHTTPServer()
 
 	opts := chromedp.DefaultExecAllocatorOptions[:]
-	if os.Getenv("WASM_HEADLESS") == "off" {
+	if certs != nil {
+		// Only the certificates of this run are trusted, not any certificate.
 		opts = append(opts,
-			chromedp.Flag("headless", false),
+			chromedp.Flag("ignore-certificate-errors-spki-list", strings.Join(certs.spkiHashes(), ",")),
 		)
 	}
 
@@ -94,6 +188,11 @@ func run(ctx context.Context, args []string, errOutput io.Writer, flagSet *flag.
 			chromedp.DisableGPU,
 		)
 	}
+	browserOpts, err := cfg.Browser.allocatorOptions()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, browserOpts...)
 
 	// create chrome instance
 	allocCtx, cancelAllocCtx := chromedp.NewExecAllocator(ctx, opts...)
@@ -106,10 +205,36 @@ func run(ctx context.Context, args []string, errOutput io.Writer, flagSet *flag.
 	})
 
 	var exitCode int
-	tasks := []chromedp.Action{
+	var tasks []chromedp.Action
+	if len(features) > 0 {
+		tasks = append(tasks, checkBrowserFeatures(features, wasmFile))
+	}
+	tasks = append(tasks,
 		chromedp.Navigate(url),
 		chromedp.WaitEnabled(`#doneButton`),
 		chromedp.Evaluate(`exitCode;`, &exitCode),
+	)
+	if mocks != nil || len(corsOrigins) > 0 {
+		ic := &interceptor{serverURL: url, mocks: mocks, cors: corsOrigins}
+		chromedp.ListenTarget(ctx, func(ev interface{}) {
+			if ev, ok := ev.(*fetch.EventRequestPaused); ok {
+				go ic.handleRequestPaused(ctx, ev, logger)
+			}
+		})
+		if mocks != nil {
+			defer mocks.report(logger)
+		}
+		tasks = append([]chromedp.Action{ic.enable()}, tasks...)
+	}
+	if harPath := cfg.Artifacts.HAR; harPath != "" {
+		har := newHARRecorder(url, cfg.Artifacts.HARFS, browserBodies(ctx))
+		chromedp.ListenTarget(ctx, har.handleEvent)
+		defer func() {
+			if err := har.writeFile(harPath); err != nil {
+				logger.Println(err)
+			}
+		}()
+		tasks = append([]chromedp.Action{network.Enable()}, tasks...)
 	}
 	if *cpuProfile != "" {
 		// Prepend and append profiling tasks
@@ -143,6 +268,9 @@ func run(ctx context.Context, args []string, errOutput io.Writer, flagSet *flag.
 	}
 
 	err = chromedp.Run(ctx, tasks...)
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("run timed out after %s", cfg.Timeout)
+	}
 	if err != nil {
 		// Browser did not exit cleanly. Likely failed with an uncaught error.
 		return err
@@ -153,6 +281,16 @@ func run(ctx context.Context, args []string, errOutput io.Writer, flagSet *flag.
 	return nil
 }
 
+// isConfigCommand reports whether arg asks for the config subcommand. A wasm
+// file named config is run instead.
+func isConfigCommand(arg string) bool {
+	if arg != "config" {
+		return false
+	}
+	_, err := os.Stat(arg)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
 func copyFile(src, dst string) error {
 	srdFd, err := os.Open(src)
 	if err != nil {
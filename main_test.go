@@ -101,6 +101,34 @@ func main() {
 	}
 }
 
+func TestRun_timeoutFlag(t *testing.T) {
+	clearWASMEnv(t)
+	t.Setenv("WASM_CONFIG", "off")
+	// The run times out before the browser starts, so none is needed.
+	_, err := testRun(t, "testdata/test.wasm", "-wbt.timeout=1ns", "-test.v")
+	assertEqualError(t, "run timed out after 1ns", err)
+}
+
+func TestIsConfigCommand(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if !isConfigCommand("config") || isConfigCommand("test.wasm") {
+		t.Error("config is not the only subcommand")
+	}
+	writeFile(t, dir, "config", "")
+	if isConfigCommand("config") {
+		t.Error("a wasm file named config is taken for the subcommand")
+	}
+}
+
 func testRun(t *testing.T, wasmFile string, flags ...string) ([]byte, error) {
 	var logs bytes.Buffer
 	flagSet := flag.NewFlagSet("wasmbrowsertest", flag.ContinueOnError)
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// mockRule answers the requests matching Method and URL. URL is a pattern
+// in which * matches any text, including slashes.
+type mockRule struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	// File is read for the body, relative to the fixtures file.
+	File string `json:"file"`
+
+	pattern *regexp.Regexp
+	body    []byte
+	used    int
+}
+
+// mockSet is a fixtures file. Requests are answered by the first matching
+// mock. Requests which match no mock fail, unless Unmatched is "pass".
+type mockSet struct {
+	Unmatched string      `json:"unmatched"`
+	Mocks     []*mockRule `json:"mocks"`
+
+	mu     sync.Mutex
+	failed []string
+}
+
+func readMocksFile(path string) (*mockSet, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mocks file: %w", err)
+	}
+	ms := &mockSet{}
+	if err := json.Unmarshal(buf, ms); err != nil {
+		return nil, fmt.Errorf("error parsing mocks file %s: %w", path, err)
+	}
+	if ms.Unmatched != "" && ms.Unmatched != "fail" && ms.Unmatched != "pass" {
+		return nil, fmt.Errorf("error parsing mocks file %s: unmatched is %q, expected fail or pass", path, ms.Unmatched)
+	}
+	for i, m := range ms.Mocks {
+		if m.URL == "" {
+			return nil, fmt.Errorf("error parsing mocks file %s: mock %d has no url", path, i)
+		}
+		m.Method = strings.ToUpper(m.Method)
+		m.pattern = globPattern(m.URL)
+		if m.Status == 0 {
+			m.Status = http.StatusOK
+		}
+		m.body = []byte(m.Body)
+		if m.File != "" {
+			file := m.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(path), file)
+			}
+			m.body, err = os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("error reading body of mock %s: %w", m.URL, err)
+			}
+			if _, ok := m.header("Content-Type"); !ok {
+				if ct := mime.TypeByExtension(filepath.Ext(file)); ct != "" {
+					if m.Headers == nil {
+						m.Headers = make(map[string]string)
+					}
+					m.Headers["Content-Type"] = ct
+				}
+			}
+		}
+	}
+	return ms, nil
+}
+
+// globPattern compiles a URL pattern in which * matches any text.
+func globPattern(glob string) *regexp.Regexp {
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+func (m *mockRule) header(name string) (string, bool) {
+	for key, value := range m.Headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func (m *mockRule) String() string {
+	method := m.Method
+	if method == "" {
+		method = "*"
+	}
+	return method + " " + m.URL
+}
+
+// mock answers a request from the matching mock, if there is one. Mocked
+// responses are usually cross-origin for the page, so they allow its
+// origin, and preflights of mocked requests are answered too.
+func (ms *mockSet) mock(method, url string, headers network.Headers) (interception, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	origin := headerValue(headers, "Origin")
+	if isPreflight(method, headers) {
+		if ms.match(headerValue(headers, "Access-Control-Request-Method"), url) == nil {
+			return interception{}, false
+		}
+		return interception{
+			action:  interceptFulfill,
+			status:  http.StatusNoContent,
+			headers: preflightHeaders(origin, headers),
+		}, true
+	}
+	m := ms.match(method, url)
+	if m == nil {
+		return interception{}, false
+	}
+	m.used++
+	h := make(map[string]string)
+	if _, ok := m.header("Access-Control-Allow-Origin"); !ok && origin != "" {
+		for key, value := range allowOriginHeaders(origin) {
+			h[key] = value
+		}
+	}
+	for key, value := range m.Headers {
+		h[key] = value
+	}
+	return interception{action: interceptFulfill, status: m.Status, headers: h, body: m.body}, true
+}
+
+// unmatched answers a request which matched no mock.
+func (ms *mockSet) unmatched(method, url string) interception {
+	if ms.Unmatched == "pass" {
+		return interception{action: interceptContinue}
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.failed = append(ms.failed, method+" "+url)
+	return interception{action: interceptFail, reason: "no mock"}
+}
+
+func (ms *mockSet) match(method, url string) *mockRule {
+	for _, m := range ms.Mocks {
+		if (m.Method == "" || m.Method == method) && m.pattern.MatchString(url) {
+			return m
+		}
+	}
+	return nil
+}
+
+// report logs how often each mock was used, and the requests which failed
+// for lack of a mock.
+func (ms *mockSet) report(logger *log.Logger) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	logger.Printf("mocks used:")
+	for _, m := range ms.Mocks {
+		logger.Printf("  %dx %s", m.used, m)
+	}
+	if len(ms.failed) > 0 {
+		logger.Printf("requests without a mock, which failed:")
+		for _, f := range ms.failed {
+			logger.Printf("  %s", f)
+		}
+	}
+}
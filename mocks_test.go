@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestMocks(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "testdata/users.json", `[{"name":"gopher"}]`)
+	writeFile(t, dir, "mocks.json", `{
+		"mocks": [
+			{"method": "get", "url": "https://api.example.com/users/*", "file": "testdata/users.json"},
+			{"method": "POST", "url": "https://api.example.com/users", "status": 201, "headers": {"Location": "/users/1"}, "body": "created"},
+			{"url": "https://cdn.example.com/*.png", "status": 404},
+			{"url": "https://unused.example.com/"}
+		]
+	}`)
+	ms, err := readMocksFile(filepath.Join(dir, "mocks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const server = "http://127.0.0.1:4321"
+	ic := &interceptor{serverURL: server, mocks: ms}
+	origin := network.Headers{"origin": server}
+
+	for _, tc := range []struct {
+		method   string
+		url      string
+		headers  network.Headers
+		expected interception
+	}{
+		{"GET", "https://api.example.com/users/1/posts", nil, interception{
+			action:  interceptFulfill,
+			status:  200,
+			headers: map[string]string{"Content-Type": "application/json"},
+			body:    []byte(`[{"name":"gopher"}]`),
+		}},
+		{"POST", "https://api.example.com/users", origin, interception{
+			action: interceptFulfill,
+			status: 201,
+			headers: map[string]string{
+				"Location":                         "/users/1",
+				"Access-Control-Allow-Origin":      server,
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "*",
+				"Vary":                             "Origin",
+			},
+			body: []byte("created"),
+		}},
+		{"OPTIONS", "https://api.example.com/users", network.Headers{
+			"Origin":                         server,
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "content-type",
+		}, interception{
+			action: interceptFulfill,
+			status: 204,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":      server,
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "*",
+				"Access-Control-Allow-Methods":     "POST",
+				"Access-Control-Allow-Headers":     "content-type",
+				"Access-Control-Max-Age":           "600",
+				"Vary":                             "Origin",
+			},
+		}},
+		{"HEAD", "https://cdn.example.com/img/logo.png", nil, interception{action: interceptFulfill, status: 404, headers: map[string]string{}, body: []byte{}}},
+		{"GET", server + "/test.wasm", nil, interception{action: interceptContinue}},
+		{"DELETE", "https://api.example.com/users/1", nil, interception{action: interceptFail, reason: "no mock"}},
+		{"GET", "https://api.example.com/users", nil, interception{action: interceptFail, reason: "no mock"}},
+	} {
+		in := ic.intercept(tc.method, tc.url, tc.headers)
+		if !reflect.DeepEqual(in, tc.expected) {
+			t.Errorf("%s %s: got %+v, expected %+v", tc.method, tc.url, in, tc.expected)
+		}
+	}
+
+	var logs bytes.Buffer
+	ms.report(log.New(&logs, "", 0))
+	for _, expected := range []string{
+		"  1x GET https://api.example.com/users/*\n",
+		"  1x POST https://api.example.com/users\n",
+		"  1x * https://cdn.example.com/*.png\n",
+		"  0x * https://unused.example.com/\n",
+		"requests without a mock, which failed:\n  DELETE https://api.example.com/users/1\n  GET https://api.example.com/users\n",
+	} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Errorf("report does not contain %q:\n%s", expected, logs.String())
+		}
+	}
+
+	ms.Unmatched = "pass"
+	if in := ic.intercept("GET", "https://example.org/", nil); in.action != interceptContinue {
+		t.Errorf("unmatched request was not passed: %+v", in)
+	}
+}
+
+func TestReadMocksFile_errors(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"unmatched.json": `{"unmatched": "ignore"}`,
+		"nourl.json":     `{"mocks": [{"method": "GET"}]}`,
+		"nofile.json":    `{"mocks": [{"url": "https://example.com/", "file": "missing.json"}]}`,
+		"invalid.json":   `{"mocks": {}}`,
+	} {
+		writeFile(t, dir, name, contents)
+		if _, err := readMocksFile(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
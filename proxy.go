@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// addProxies forwards the requests below the prefixes of mounts to their
+// target URLs. The request path is kept and appended to the path of the
+// target, so /api/=http://localhost:8080 forwards /api/users to
+// http://localhost:8080/api/users. The page keeps talking to its own
+// origin, so no CORS headers are needed. With logRequests, every proxied
+// request is logged with its status and duration.
+func (ws *wasmServer) addProxies(mounts []mount, logRequests bool) error {
+	for _, m := range mounts {
+		target, err := url.Parse(m.target)
+		if err != nil {
+			return fmt.Errorf("error parsing proxy target: %w", err)
+		}
+		if target.Scheme != "http" && target.Scheme != "https" || target.Host == "" {
+			return fmt.Errorf("proxy target %q is not an http or https URL", m.target)
+		}
+		ws.addRoute(m.prefix, ws.proxyHandler(target, logRequests))
+	}
+	return nil
+}
+
+func (ws *wasmServer) proxyHandler(target *url.URL, logRequests bool) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			ws.logger.Printf("proxy error: %s %s: %v", r.Method, r.URL, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	if !logRequests {
+		return proxy
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		proxy.ServeHTTP(sw, r)
+		ws.logger.Printf("proxy: %s %s -> %s: %d (%s)", r.Method, r.URL.RequestURI(), target, sw.status, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+// statusWriter keeps the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes streamed responses through as they arrive.
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection, which the proxy
+// hijacks for upgraded requests like WebSockets.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Backend", "yes")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+string(body)+" "+r.Header.Get("X-Forwarded-Host"))
+	}))
+	defer backend.Close()
+
+	srv := newTestWASMServer(t)
+	var logs bytes.Buffer
+	srv.logger = log.New(&logs, "", 0)
+	for _, target := range []string{"localhost:8080", "ftp://localhost", "http://"} {
+		if err := srv.addProxies([]mount{{"/api/", target}}, false); err == nil {
+			t.Errorf("expected error for proxy target %q", target)
+		}
+	}
+	err := srv.addProxies([]mount{
+		{"/api/", backend.URL},
+		{"/v2/", backend.URL + "/base"},
+		{"/down/", "http://127.0.0.1:1"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{"/api/users?id=1", "POST /api/users?id=1 data example.com"},
+		{"/v2/users", "POST /base/v2/users data example.com"},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader("data")))
+		if w.Code != http.StatusCreated || w.Body.String() != tc.expected || w.Header().Get("X-Backend") != "yes" {
+			t.Errorf("POST %s: status %d, body %q", tc.path, w.Code, w.Body.String())
+		}
+	}
+	if !strings.Contains(logs.String(), "proxy: POST /api/users?id=1 -> "+backend.URL+": 201") {
+		t.Errorf("request was not logged:\n%s", logs.String())
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/down/x", nil))
+	if w.Code != http.StatusBadGateway || !strings.Contains(logs.String(), "proxy error: GET http://127.0.0.1:1/down/x") {
+		t.Errorf("unreachable backend: status %d, logs:\n%s", w.Code, logs.String())
+	}
+}
+
+func TestProxy_upgrade(t *testing.T) {
+	// The backend switches to a protocol echoing what it reads.
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "expected an upgrade", http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+	defer backend.Close()
+
+	srv := newTestWASMServer(t)
+	var logs bytes.Buffer
+	srv.logger = log.New(&logs, "", 0)
+	if err := srv.addProxies([]mount{{"/ws/", backend.URL}}, true); err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(srv)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /ws/echo HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, logs:\n%s", res.StatusCode, logs.String())
+	}
+	io.WriteString(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("read %q, %v, expected the echo", buf, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// route serves every request whose path starts with prefix.
+type route struct {
+	prefix  string
+	handler http.Handler
+}
+
+// mount is a URL prefix mapped to a target, like a directory or a URL.
+type mount struct {
+	prefix string
+	target string
+}
+
+// parseMounts parses a comma separated list of prefix=target pairs. Prefixes
+// are made to start and end with a slash, so /testdata and /testdata/ are
+// the same mount.
+func parseMounts(s string) ([]mount, error) {
+	var mounts []mount
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, target, ok := strings.Cut(entry, "=")
+		if !ok || target == "" {
+			return nil, fmt.Errorf("invalid mount %q, expected prefix=target", entry)
+		}
+		mounts = append(mounts, mount{prefix: cleanPrefix(prefix), target: target})
+	}
+	return mounts, nil
+}
+
+func cleanPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "/"
+	}
+	return "/" + prefix + "/"
+}
+
+// addRoute serves the requests below prefix with h. The most specific
+// prefix wins when routes overlap.
+func (ws *wasmServer) addRoute(prefix string, h http.Handler) {
+	ws.routes = append(ws.routes, route{prefix: prefix, handler: h})
+	sort.SliceStable(ws.routes, func(i, j int) bool {
+		return len(ws.routes[i].prefix) > len(ws.routes[j].prefix)
+	})
+}
+
+// serveRoute serves r from the first matching route. Requests which match
+// nothing get a 404, which is logged since it usually means a missing mount.
+func (ws *wasmServer) serveRoute(w http.ResponseWriter, r *http.Request) {
+	for _, rt := range ws.routes {
+		if strings.HasPrefix(r.URL.Path, rt.prefix) {
+			rt.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	// Browsers ask for an icon on their own, that is not worth a warning.
+	if r.URL.Path != "/favicon.ico" {
+		ws.logger.Printf("warning: nothing is served at %s %s, responding with 404", r.Method, r.URL.Path)
+	}
+	http.NotFound(w, r)
+}
+
+// addStaticDirs serves the directories of mounts below their prefixes.
+func (ws *wasmServer) addStaticDirs(mounts []mount) error {
+	for _, m := range mounts {
+		info, err := os.Stat(m.target)
+		if err != nil {
+			return fmt.Errorf("error serving static directory: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("error serving static directory: %s is not a directory", m.target)
+		}
+		ws.addRoute(m.prefix, staticHandler(m.prefix, m.target))
+	}
+	return nil
+}
+
+// staticHandler serves the files of dir below prefix. The MIME type comes
+// from the file extension. Unless a Cache-Control header is configured,
+// files are revalidated on every request, so a test always sees their
+// current content.
+func staticHandler(prefix, dir string) http.Handler {
+	files := http.StripPrefix(strings.TrimSuffix(prefix, "/"), http.FileServer(http.Dir(dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
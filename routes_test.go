@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMounts(t *testing.T) {
+	for _, tc := range []struct {
+		input     string
+		expected  []mount
+		expectErr bool
+	}{
+		{input: "/testdata/=testdata", expected: []mount{{"/testdata/", "testdata"}}},
+		{input: "testdata=testdata", expected: []mount{{"/testdata/", "testdata"}}},
+		{input: "/=web, /assets=web/assets,", expected: []mount{{"/", "web"}, {"/assets/", "web/assets"}}},
+		{input: "/a/b=C:\\data", expected: []mount{{"/a/b/", "C:\\data"}}},
+		{input: "/testdata/", expectErr: true},
+		{input: "/testdata/=", expectErr: true},
+	} {
+		mounts, err := parseMounts(tc.input)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(mounts, tc.expected) {
+			t.Errorf("%q: got %v, expected %v", tc.input, mounts, tc.expected)
+		}
+	}
+}
+
+func TestStaticDirs(t *testing.T) {
+	srv := newTestWASMServer(t)
+	var logs bytes.Buffer
+	srv.logger = log.New(&logs, "", 0)
+
+	dir := t.TempDir()
+	writeFile(t, dir, "data/fixture.json", `{"ok":true}`)
+	writeFile(t, dir, "data/worker.mjs", `onmessage = () => {}`)
+	writeFile(t, dir, "data/nested/fixture.json", `{"nested":true}`)
+	writeFile(t, dir, "other/fixture.json", `{"other":true}`)
+
+	err := srv.addStaticDirs([]mount{{"/missing/", dir + "/missing"}})
+	if err == nil || !strings.Contains(err.Error(), "error serving static directory") {
+		t.Fatalf("expected error for a missing directory, got %v", err)
+	}
+	err = srv.addStaticDirs([]mount{
+		{"/testdata/", dir + "/data"},
+		{"/testdata/nested/", dir + "/other"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path        string
+		body        string
+		contentType string
+	}{
+		{"/testdata/fixture.json", `{"ok":true}`, "application/json"},
+		{"/testdata/worker.mjs", `onmessage = () => {}`, "text/javascript; charset=utf-8"},
+		// The more specific mount wins.
+		{"/testdata/nested/fixture.json", `{"other":true}`, "application/json"},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != tc.body {
+			t.Errorf("GET %s: status %d, body %q", tc.path, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("GET %s: content type %q, expected %q", tc.path, ct, tc.contentType)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != "no-cache" {
+			t.Errorf("GET %s: cache control %q", tc.path, cc)
+		}
+	}
+
+	for _, path := range []string{"/testdata/missing.json", "/testdata/../other/fixture.json", "/unknown.js", "/favicon.ico"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, expected 404", path, w.Code)
+		}
+	}
+	if !strings.Contains(logs.String(), "GET /unknown.js") {
+		t.Errorf("expected a warning for /unknown.js, got %q", logs.String())
+	}
+	if strings.Contains(logs.String(), "favicon.ico") {
+		t.Errorf("expected no warning for /favicon.ico, got %q", logs.String())
+	}
+}
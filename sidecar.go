@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sidecarConfig describes a helper process, like a fake API, which runs
+// next to the browser for the whole run. ${PORT} in Command, Env, Ready and
+// Export is replaced with a free port allocated for the sidecar.
+type sidecarConfig struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+	// Dir is the working directory, relative to the sidecars file.
+	Dir string `json:"dir"`
+	// Env is added to the environment of the process.
+	Env map[string]string `json:"env"`
+	// Ready is probed until the sidecar is ready: tcp://host:port is ready
+	// once the port accepts connections, an http or https URL once it
+	// responds with 200.
+	Ready string `json:"ready"`
+	// ReadyTimeout is how long to wait for Ready, 30s by default.
+	ReadyTimeout string `json:"readyTimeout"`
+	// Export is added to the environment of the wasm program, usually
+	// the URL of the sidecar.
+	Export map[string]string `json:"export"`
+}
+
+const (
+	defaultReadyTimeout = 30 * time.Second
+	readyPollInterval   = 50 * time.Millisecond
+	// sidecarStopDelay is how long a sidecar has to exit after the
+	// interrupt, before it is killed.
+	sidecarStopDelay = 5 * time.Second
+)
+
+// readSidecarsFile reads a JSON array of sidecar configs.
+func readSidecarsFile(path string) ([]sidecarConfig, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading sidecars file: %w", err)
+	}
+	var configs []sidecarConfig
+	if err := json.Unmarshal(buf, &configs); err != nil {
+		return nil, fmt.Errorf("error parsing sidecars file %s: %w", path, err)
+	}
+	for i, c := range configs {
+		if c.Name == "" || len(c.Command) == 0 {
+			return nil, fmt.Errorf("error parsing sidecars file %s: sidecar %d needs a name and a command", path, i)
+		}
+		if c.ReadyTimeout != "" {
+			if _, err := time.ParseDuration(c.ReadyTimeout); err != nil {
+				return nil, fmt.Errorf("error parsing sidecars file %s: sidecar %s: %w", path, c.Name, err)
+			}
+		}
+		if c.Dir != "" && !filepath.IsAbs(c.Dir) {
+			configs[i].Dir = filepath.Join(filepath.Dir(path), c.Dir)
+		}
+	}
+	return configs, nil
+}
+
+type sidecar struct {
+	name string
+	cmd  *exec.Cmd
+	// exited is closed once the process exited, err is then its result.
+	exited chan struct{}
+	err    error
+}
+
+// sidecars are the running helper processes of a run.
+type sidecars struct {
+	procs []*sidecar
+	// env is what the sidecars export to the wasm program.
+	env    map[string]string
+	logger *log.Logger
+}
+
+// startSidecars starts the sidecars in order, each one once the previous one
+// is ready. Their output is written to logOutput, every line prefixed with
+// the sidecar name. On error, the sidecars already started are stopped.
+func startSidecars(ctx context.Context, configs []sidecarConfig, logOutput io.Writer, logger *log.Logger) (*sidecars, error) {
+	sc := &sidecars{env: make(map[string]string), logger: logger}
+	for _, c := range configs {
+		if err := sc.start(ctx, c, logOutput); err != nil {
+			sc.Close()
+			return nil, fmt.Errorf("error starting sidecar %s: %w", c.Name, err)
+		}
+	}
+	return sc, nil
+}
+
+func (sc *sidecars) start(ctx context.Context, c sidecarConfig, logOutput io.Writer) error {
+	port, err := freePort()
+	if err != nil {
+		return err
+	}
+	// Only ${PORT} is replaced, a $ in a command is left to the command.
+	expand := func(s string) string {
+		return strings.ReplaceAll(s, "${PORT}", strconv.Itoa(port))
+	}
+
+	args := make([]string, len(c.Command))
+	for i, arg := range c.Command {
+		args[i] = expand(arg)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = c.Dir
+	cmd.Env = append(os.Environ(), "PORT="+strconv.Itoa(port))
+	for key, value := range c.Env {
+		cmd.Env = append(cmd.Env, key+"="+expand(value))
+	}
+	out := &prefixWriter{w: logOutput, prefix: "[" + c.Name + "] "}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// Give the sidecar a chance to clean up, interrupts are not supported
+	// on windows though.
+	if runtime.GOOS != "windows" {
+		cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	}
+	cmd.WaitDelay = sidecarStopDelay
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	p := &sidecar{name: c.Name, cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		out.flush()
+		close(p.exited)
+	}()
+	sc.procs = append(sc.procs, p)
+
+	if c.Ready != "" {
+		timeout := defaultReadyTimeout
+		if c.ReadyTimeout != "" {
+			timeout, _ = time.ParseDuration(c.ReadyTimeout)
+		}
+		if err := waitReady(ctx, p, expand(c.Ready), timeout); err != nil {
+			return err
+		}
+	}
+	for key, value := range c.Export {
+		sc.env[key] = expand(value)
+	}
+	return nil
+}
+
+// waitReady probes ready until it succeeds, the sidecar exits or the
+// timeout passes.
+func waitReady(ctx context.Context, p *sidecar, ready string, timeout time.Duration) error {
+	u, err := url.Parse(ready)
+	if err != nil {
+		return fmt.Errorf("invalid readiness probe: %w", err)
+	}
+	var probe func(ctx context.Context) error
+	switch u.Scheme {
+	case "tcp":
+		probe = func(ctx context.Context) error {
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", u.Host)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+	case "http", "https":
+		probe = func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, ready, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("%s responded with %s", ready, resp.Status)
+			}
+			return nil
+		}
+	default:
+		return fmt.Errorf("invalid readiness probe %q, expected a tcp, http or https URL", ready)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		probeCtx, cancelProbe := context.WithTimeout(ctx, time.Second)
+		err := probe(probeCtx)
+		cancelProbe()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-p.exited:
+			return fmt.Errorf("exited before it was ready: %v", p.err)
+		case <-ctx.Done():
+			return fmt.Errorf("not ready after %s: %w", timeout, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the sidecars, the last started first, and waits for them
+// to exit. Sidecars which exited early are reported.
+func (sc *sidecars) Close() {
+	for i := len(sc.procs) - 1; i >= 0; i-- {
+		p := sc.procs[i]
+		select {
+		case <-p.exited:
+			sc.logger.Printf("sidecar %s exited early: %v", p.name, p.err)
+			continue
+		default:
+		}
+		var err error
+		if runtime.GOOS == "windows" {
+			err = p.cmd.Process.Kill()
+		} else {
+			err = p.cmd.Process.Signal(os.Interrupt)
+		}
+		if err != nil && !errors.Is(err, os.ErrProcessDone) {
+			sc.logger.Printf("error stopping sidecar %s: %v", p.name, err)
+		}
+		select {
+		case <-p.exited:
+		case <-time.After(sidecarStopDelay):
+			if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+				sc.logger.Printf("error killing sidecar %s: %v", p.name, err)
+			}
+			<-p.exited
+		}
+	}
+}
+
+// freePort returns a port which is free on localhost right now.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// prefixWriter writes every line with a prefix. Output is written line by
+// line, so the lines of concurrent sidecars do not mix.
+type prefixWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := fmt.Fprintf(pw.w, "%s%s\n", pw.prefix, pw.buf[:i]); err != nil {
+			return 0, err
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush writes the last line, if it did not end with a newline.
+func (pw *prefixWriter) flush() {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if len(pw.buf) > 0 {
+		fmt.Fprintf(pw.w, "%s%s\n", pw.prefix, pw.buf)
+		pw.buf = nil
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestSidecarHelperProcess is not a real test, the sidecar tests run the
+// test binary with it as their sidecar.
+func TestSidecarHelperProcess(t *testing.T) {
+	if os.Getenv("WBT_SIDECAR_HELPER") != "1" {
+		t.Skip("helper process for the sidecar tests")
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	if os.Getenv("WBT_SIDECAR_FAIL") == "1" {
+		fmt.Println("failing")
+		os.Exit(3)
+	}
+	l, err := net.Listen("tcp", "localhost:"+os.Getenv("PORT"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("listening on %s\n", l.Addr())
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	<-interrupt
+	fmt.Println("stopping")
+	os.Exit(0)
+}
+
+func helperSidecar(name string, env map[string]string) sidecarConfig {
+	env["WBT_SIDECAR_HELPER"] = "1"
+	return sidecarConfig{
+		Name:    name,
+		Command: []string{os.Args[0], "-test.run=^TestSidecarHelperProcess$"},
+		Env:     env,
+		Ready:   "http://localhost:${PORT}/",
+		Export:  map[string]string{strings.ToUpper(name) + "_URL": "http://localhost:${PORT}"},
+	}
+}
+
+// syncBuffer is written by the sidecar output goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+func TestSidecars(t *testing.T) {
+	var out syncBuffer
+	api := helperSidecar("api", map[string]string{})
+	api.Export["API_HOME"] = "$HOME"
+	sc, err := startSidecars(context.Background(), []sidecarConfig{
+		api,
+		helperSidecar("echo", map[string]string{}),
+	}, &out, log.New(&out, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"API_URL", "ECHO_URL"} {
+		resp, err := http.Get(sc.env[name])
+		if err != nil {
+			t.Fatalf("%s is not ready: %v", name, err)
+		}
+		resp.Body.Close()
+	}
+	if sc.env["API_URL"] == sc.env["ECHO_URL"] {
+		t.Errorf("sidecars share a port: %v", sc.env)
+	}
+	if sc.env["API_HOME"] != "$HOME" {
+		t.Errorf("only ${PORT} is replaced, got %q", sc.env["API_HOME"])
+	}
+	sc.Close()
+
+	for _, expected := range []string{"[api] listening on 127.0.0.1:", "[echo] listening on 127.0.0.1:", "[api] stopping\n", "[echo] stopping\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output does not contain %q:\n%s", expected, out.String())
+		}
+	}
+	if strings.Index(out.String(), "[echo] stopping") > strings.Index(out.String(), "[api] stopping") {
+		t.Errorf("sidecars were not stopped in reverse order:\n%s", out.String())
+	}
+}
+
+func TestSidecars_notReady(t *testing.T) {
+	failing := helperSidecar("failing", map[string]string{"WBT_SIDECAR_FAIL": "1"})
+	slow := helperSidecar("slow", map[string]string{})
+	slow.Ready = "tcp://localhost:1"
+	slow.ReadyTimeout = "200ms"
+
+	for _, tc := range []struct {
+		configs   []sidecarConfig
+		expectErr string
+		expectOut string
+	}{
+		{[]sidecarConfig{failing}, "error starting sidecar failing: exited before it was ready: exit status 3", "[failing] failing\n"},
+		{[]sidecarConfig{helperSidecar("api", map[string]string{}), slow}, "error starting sidecar slow: not ready after 200ms", "[api] stopping\n"},
+	} {
+		var out syncBuffer
+		_, err := startSidecars(context.Background(), tc.configs, &out, log.New(io.Discard, "", 0))
+		if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+			t.Errorf("expected error %q, got %v", tc.expectErr, err)
+		}
+		if !strings.Contains(out.String(), tc.expectOut) {
+			t.Errorf("output does not contain %q:\n%s", tc.expectOut, out.String())
+		}
+	}
+}
+
+func TestReadSidecarsFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "ok.json", `[{"name": "api", "command": ["fakeapi", "-port", "${PORT}"], "dir": "fakeapi", "ready": "tcp://localhost:${PORT}", "readyTimeout": "5s"}]`)
+	writeFile(t, dir, "noname.json", `[{"command": ["fakeapi"]}]`)
+	writeFile(t, dir, "timeout.json", `[{"name": "api", "command": ["fakeapi"], "readyTimeout": "5"}]`)
+
+	configs, err := readSidecarsFile(filepath.Join(dir, "ok.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].Name != "api" || configs[0].Command[2] != "${PORT}" || configs[0].Dir != filepath.Join(dir, "fakeapi") {
+		t.Errorf("unexpected configs %+v", configs)
+	}
+	for _, file := range []string{"noname.json", "timeout.json", "missing.json"} {
+		if _, err := readSidecarsFile(filepath.Join(dir, file)); err == nil {
+			t.Errorf("%s: expected an error", file)
+		}
+	}
+}
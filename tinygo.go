@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const wasmImportSection = 2
+
+// wasmImport is a function, table, memory or global the module imports.
+type wasmImport struct {
+	module, name string
+}
+
+// imports returns what the module imports.
+func (m *wasmModule) imports() ([]wasmImport, error) {
+	var imports []wasmImport
+	for _, s := range m.sections {
+		if s.id != wasmImportSection {
+			continue
+		}
+		r := &wasmReader{buf: s.data}
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			imp := wasmImport{module: r.name(), name: r.name()}
+			switch kind := r.byte(); kind {
+			case 0x00: // function
+				r.u32()
+			case 0x01: // table
+				r.byte()
+				r.limits()
+			case 0x02: // memory
+				r.limits()
+			case 0x03: // global
+				r.byte()
+				r.byte()
+			default:
+				if r.err == nil {
+					r.err = fmt.Errorf("unknown import kind %#x", kind)
+				}
+			}
+			imports = append(imports, imp)
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("invalid import section: %w", r.err)
+		}
+	}
+	return imports, nil
+}
+
+// limits skips the limits of a table or memory.
+func (r *wasmReader) limits() {
+	flags := r.byte()
+	r.leb() // min
+	if flags&0x01 != 0 {
+		r.leb() // max
+	}
+}
+
+// tinygoImports are imported by TinyGo binaries only, the Go runtime
+// schedules with other functions.
+var tinygoImports = map[string]bool{
+	"runtime.ticks":      true,
+	"runtime.sleepTicks": true,
+}
+
+// isTinyGo reports whether TinyGo built the module, as its producers
+// section says, or else as its imports of the TinyGo runtime say.
+func (m *wasmModule) isTinyGo() (bool, error) {
+	if data := m.customSection("producers"); data != nil {
+		r := &wasmReader{buf: data}
+		for fields := r.u32(); fields > 0 && r.err == nil; fields-- {
+			r.name() // field
+			for values := r.u32(); values > 0 && r.err == nil; values-- {
+				name, _ := r.name(), r.name()
+				if strings.HasPrefix(name, "TinyGo") && r.err == nil {
+					return true, nil
+				}
+			}
+		}
+	}
+	imports, err := m.imports()
+	if err != nil {
+		return false, err
+	}
+	for _, imp := range imports {
+		if (imp.module == "gojs" || imp.module == "env") && tinygoImports[imp.name] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// tinygoWasmExecJS is where a TinyGo installation has its wasm_exec.js.
+var tinygoWasmExecJS = filepath.Join("targets", "wasm_exec.js")
+
+// loadTinyGoWasmExec loads the wasm_exec.js of the TinyGo installation at
+// root, or of the one tinygo env reports if root is not set.
+func (ws *wasmServer) loadTinyGoWasmExec(root string) error {
+	if root == "" {
+		var err error
+		root, err = tinygoRoot()
+		if err != nil {
+			return fmt.Errorf("cannot find TinyGo, which built %s: %w\nInstall TinyGo, or set WASM_TINYGOROOT to its installation", ws.wasmFile, err)
+		}
+	}
+	buf, err := os.ReadFile(filepath.Join(root, tinygoWasmExecJS))
+	if err != nil {
+		return fmt.Errorf("error reading the wasm_exec.js of TinyGo, which built %s: %w", ws.wasmFile, err)
+	}
+	ws.wasmExecJS = buf
+	return nil
+}
+
+// tinygoRoot runs tinygo env to find the TinyGo installation. It is a
+// variable for the tests.
+var tinygoRoot = func() (string, error) {
+	out, err := exec.Command("tinygo", "env", "TINYGOROOT").Output()
+	if err != nil {
+		return "", err
+	}
+	root := strings.TrimSpace(string(out))
+	if root == "" {
+		return "", errors.New("tinygo env reports no TINYGOROOT")
+	}
+	return root, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wasmTestImports returns an import section of functions for
+// wasmTestModule.
+func wasmTestImports(imports ...wasmImport) []byte {
+	s := appendU32([]byte{wasmImportSection}, uint32(len(imports)))
+	for _, imp := range imports {
+		s = appendName(appendName(s, imp.module), imp.name)
+		s = append(s, 0x00, 0x00) // function of type 0
+	}
+	return s
+}
+
+// stubTinyGoRoot makes tinygoRoot answer root, or fail if it is empty.
+func stubTinyGoRoot(t *testing.T, root string) {
+	t.Helper()
+	orig := tinygoRoot
+	tinygoRoot = func() (string, error) {
+		if root == "" {
+			return "", errors.New(`exec: "tinygo": executable file not found in $PATH`)
+		}
+		return root, nil
+	}
+	t.Cleanup(func() { tinygoRoot = orig })
+}
+
+func TestIsTinyGo(t *testing.T) {
+	tinygoProducers := appendU32(nil, 1)
+	tinygoProducers = appendName(tinygoProducers, "processed-by")
+	tinygoProducers = appendU32(tinygoProducers, 1)
+	tinygoProducers = appendName(appendName(tinygoProducers, "TinyGo"), "0.34.0")
+
+	// A table, a memory with a maximum and a global, before the functions.
+	others := appendU32([]byte{wasmImportSection}, 4)
+	others = append(appendName(appendName(others, "env"), "table"), 0x01, 0x70, 0x00, 0x01)
+	others = append(appendName(appendName(others, "env"), "memory"), 0x02, 0x01, 0x02, 0x80, 0x01)
+	others = append(appendName(appendName(others, "env"), "global"), 0x03, 0x7f, 0x00)
+	others = append(appendName(appendName(others, "gojs"), "runtime.sleepTicks"), 0x00, 0x00)
+
+	for _, tc := range []struct {
+		description string
+		module      []byte
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			description: "go",
+			module: wasmTestModule(wasmTestProducers("go1.24.3"), wasmTestImports(
+				wasmImport{"gojs", "runtime.wasmExit"},
+				wasmImport{"gojs", "runtime.nanotime1"},
+			)),
+		},
+		{
+			description: "tinygo imports",
+			module: wasmTestModule(wasmTestImports(
+				wasmImport{"wasi_snapshot_preview1", "fd_write"},
+				wasmImport{"gojs", "runtime.ticks"},
+			)),
+			expected: true,
+		},
+		{
+			description: "older tinygo imports",
+			module:      wasmTestModule(wasmTestImports(wasmImport{"env", "runtime.sleepTicks"})),
+			expected:    true,
+		},
+		{
+			description: "tinygo producers",
+			module:      wasmTestModule(wasmTestCustomSection("producers", tinygoProducers)),
+			expected:    true,
+		},
+		{
+			description: "other imports",
+			module:      wasmTestModule(others),
+			expected:    true,
+		},
+		{
+			description: "unknown import kind",
+			module:      wasmTestModule(append(appendName(appendName([]byte{wasmImportSection, 1}, "env"), "tag"), 0x04, 0x00, 0x00)),
+			expectErr:   true,
+		},
+		{
+			description: "truncated imports",
+			module:      wasmTestModule(appendName([]byte{wasmImportSection, 1}, "env")),
+			expectErr:   true,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			m, err := parseWasmModule(tc.module)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tinygo, err := m.isTinyGo()
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tinygo != tc.expected {
+				t.Errorf("got %v, expected %v", tinygo, tc.expected)
+			}
+		})
+	}
+
+	m, err := readWasmModule("testdata/test.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tinygo, err := m.isTinyGo(); err != nil || tinygo {
+		t.Errorf("testdata/test.wasm: got %v, %v, expected a Go binary", tinygo, err)
+	}
+}
+
+func TestLoadTinyGoWasmExec(t *testing.T) {
+	m, err := parseWasmModule(wasmTestModule(wasmTestImports(wasmImport{"gojs", "runtime.ticks"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := &wasmServer{wasmFile: "test.wasm", logger: log.New(io.Discard, "", 0)}
+
+	stubTinyGoRoot(t, "")
+	err = ws.loadWasmExec(m, serverConfig{})
+	if err == nil || !strings.HasPrefix(err.Error(), "cannot find TinyGo, which built test.wasm") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	installed := t.TempDir()
+	installedJS := writeWasmExec(t, installed, "targets/wasm_exec.js")
+	stubTinyGoRoot(t, installed)
+	if err := ws.loadWasmExec(m, serverConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if string(ws.wasmExecJS) != "// "+installedJS || !ws.tinygo {
+		t.Errorf("unexpected wasm_exec.js %q, tinygo %v", ws.wasmExecJS, ws.tinygo)
+	}
+
+	configured := t.TempDir()
+	configuredJS := writeWasmExec(t, configured, "targets/wasm_exec.js")
+	if err := ws.loadWasmExec(m, serverConfig{TinyGoRoot: configured}); err != nil {
+		t.Fatal(err)
+	}
+	if string(ws.wasmExecJS) != "// "+configuredJS {
+		t.Errorf("unexpected wasm_exec.js %q", ws.wasmExecJS)
+	}
+
+	err = ws.loadWasmExec(m, serverConfig{TinyGoRoot: filepath.Join(configured, "missing")})
+	if err == nil || !strings.HasPrefix(err.Error(), "error reading the wasm_exec.js of TinyGo") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestTinyGoHarness(t *testing.T) {
+	srv := newTestWASMServer(t)
+	if body := serveTest(t, srv, "/"); strings.Contains(body, "proc_exit") {
+		t.Error("the harness of a Go binary wraps proc_exit")
+	}
+	srv.tinygo = true
+	if body := serveTest(t, srv, "/"); !strings.Contains(body, "if (wasi && wasi.proc_exit)") {
+		t.Error("the harness of a TinyGo binary does not wrap proc_exit")
+	}
+}
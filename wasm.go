@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// wasmModule is a WebAssembly binary, split in sections, as far as the
+// runner needs to know it.
+type wasmModule struct {
+	sections []wasmSection
+}
+
+type wasmSection struct {
+	id byte
+	// name is the name of a custom section.
+	name string
+	// data is the content of the section, after the name of a custom
+	// section.
+	data []byte
+}
+
+const wasmCustomSection = 0
+
+var (
+	wasmMagic   = []byte("\x00asm")
+	wasmVersion = []byte{1, 0, 0, 0}
+
+	errWasmTruncated = errors.New("unexpected end of data")
+)
+
+func readWasmModule(path string) (*wasmModule, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseWasmModule(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid WebAssembly module: %w", path, err)
+	}
+	return m, nil
+}
+
+func parseWasmModule(buf []byte) (*wasmModule, error) {
+	if len(buf) < 8 || !bytes.Equal(buf[:4], wasmMagic) {
+		return nil, errors.New("it does not start with the WebAssembly magic number")
+	}
+	if !bytes.Equal(buf[4:8], wasmVersion) {
+		return nil, fmt.Errorf("unsupported WebAssembly version %x", buf[4:8])
+	}
+	m := &wasmModule{}
+	r := &wasmReader{buf: buf, off: 8}
+	for r.off < len(r.buf) {
+		start := r.off
+		id := r.byte()
+		data := r.bytes(int(r.u32()))
+		if r.err != nil {
+			return nil, fmt.Errorf("section at offset %d: %w", start, r.err)
+		}
+		s := wasmSection{id: id, data: data}
+		if id == wasmCustomSection {
+			cr := &wasmReader{buf: data}
+			s.name = cr.name()
+			if cr.err != nil {
+				return nil, fmt.Errorf("custom section at offset %d: %w", start, cr.err)
+			}
+			s.data = data[cr.off:]
+		}
+		m.sections = append(m.sections, s)
+	}
+	return m, nil
+}
+
+// customSection returns the data of the custom section name, or nil.
+func (m *wasmModule) customSection(name string) []byte {
+	for _, s := range m.sections {
+		if s.id == wasmCustomSection && s.name == name {
+			return s.data
+		}
+	}
+	return nil
+}
+
+// goVersion returns the Go version which built the module, like go1.24.3,
+// from its producers section, or "" if it does not say.
+func (m *wasmModule) goVersion() string {
+	data := m.customSection("producers")
+	if data == nil {
+		return ""
+	}
+	r := &wasmReader{buf: data}
+	for fields := r.u32(); fields > 0 && r.err == nil; fields-- {
+		field := r.name()
+		for values := r.u32(); values > 0 && r.err == nil; values-- {
+			name, version := r.name(), r.name()
+			if field == "language" && name == "Go" && r.err == nil {
+				return version
+			}
+		}
+	}
+	return ""
+}
+
+// wasmReader reads the encodings of the WebAssembly binary format. The
+// first error is kept in err, reads after it return zero values.
+type wasmReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *wasmReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if r.off >= len(r.buf) {
+		r.err = errWasmTruncated
+		return 0
+	}
+	b := r.buf[r.off]
+	r.off++
+	return b
+}
+
+// u32 reads an unsigned LEB128 integer of at most 32 bits.
+func (r *wasmReader) u32() uint32 {
+	var v uint32
+	for shift := 0; shift < 35; shift += 7 {
+		b := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		v |= uint32(b&0x7f) << shift
+		if b < 0x80 {
+			return v
+		}
+	}
+	r.err = errors.New("integer too large")
+	return 0
+}
+
+// leb skips a signed or unsigned LEB128 integer of at most 64 bits.
+func (r *wasmReader) leb() {
+	for i := 0; i < 10; i++ {
+		if b := r.byte(); b < 0x80 || r.err != nil {
+			return
+		}
+	}
+	r.err = errors.New("integer too large")
+}
+
+func (r *wasmReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf)-r.off {
+		r.err = errWasmTruncated
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *wasmReader) name() string {
+	return string(r.bytes(int(r.u32())))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// wasmTestModule returns a module with the sections, each given as its id
+// followed by its content.
+func wasmTestModule(sections ...[]byte) []byte {
+	buf := append([]byte{}, wasmMagic...)
+	buf = append(buf, wasmVersion...)
+	for _, s := range sections {
+		buf = append(buf, s[0])
+		buf = appendU32(buf, uint32(len(s)-1))
+		buf = append(buf, s[1:]...)
+	}
+	return buf
+}
+
+// wasmTestCustomSection returns a custom section for wasmTestModule.
+func wasmTestCustomSection(name string, data []byte) []byte {
+	s := appendName([]byte{wasmCustomSection}, name)
+	return append(s, data...)
+}
+
+// wasmTestProducers returns a producers section naming the Go version.
+func wasmTestProducers(goVersion string) []byte {
+	data := appendU32(nil, 2)
+	data = appendName(data, "language")
+	data = appendU32(data, 1)
+	data = appendName(appendName(data, "Go"), goVersion)
+	data = appendName(data, "processed-by")
+	data = appendU32(data, 1)
+	data = appendName(appendName(data, "Go cmd/compile"), goVersion)
+	return wasmTestCustomSection("producers", data)
+}
+
+func appendU32(buf []byte, v uint32) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendName(buf []byte, name string) []byte {
+	return append(appendU32(buf, uint32(len(name))), name...)
+}
+
+func TestParseWasmModule(t *testing.T) {
+	buf := wasmTestModule(
+		wasmTestCustomSection("go:buildid", []byte("\xff Go build ID")),
+		[]byte{1, 1, 0x60, 0, 0},
+		wasmTestProducers("go1.24.3"),
+	)
+	m, err := parseWasmModule(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.sections) != 3 || m.sections[1].id != 1 || string(m.sections[0].data) != "\xff Go build ID" {
+		t.Errorf("unexpected sections %+v", m.sections)
+	}
+	if v := m.goVersion(); v != "go1.24.3" {
+		t.Errorf("got Go version %q, expected go1.24.3", v)
+	}
+
+	m, err = parseWasmModule(wasmTestModule([]byte{1, 1, 0x60, 0, 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := m.goVersion(); v != "" {
+		t.Errorf("got Go version %q, expected none", v)
+	}
+}
+
+func TestParseWasmModule_invalid(t *testing.T) {
+	valid := wasmTestModule([]byte{1, 1, 0x60, 0, 0})
+	for _, tc := range []struct {
+		description string
+		buf         []byte
+		expectErr   string
+	}{
+		{"empty", nil, "magic number"},
+		{"not wasm", []byte("#!/bin/sh\necho hello\n"), "magic number"},
+		{"version 2", append(append([]byte{}, wasmMagic...), 2, 0, 0, 0), "unsupported WebAssembly version 02000000"},
+		{"truncated section", valid[:len(valid)-1], "section at offset 8: unexpected end of data"},
+		{"truncated size", append(append([]byte{}, valid...), 1, 0x80), "unexpected end of data"},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := parseWasmModule(tc.buf)
+			if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+				t.Errorf("got %v, expected an error about %s", err, tc.expectErr)
+			}
+		})
+	}
+}
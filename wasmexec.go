@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/version"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// wasmLocations are where a GOROOT has wasm_exec.js, which moved to lib/wasm
+// in Go 1.24.
+var wasmLocations = []string{
+	"misc/wasm/wasm_exec.js",
+	"lib/wasm/wasm_exec.js",
+}
+
+// loadWasmExec loads the wasm_exec.js which matches the Go or TinyGo
+// release that built m, the wasm file, or the one sc names.
+func (ws *wasmServer) loadWasmExec(m *wasmModule, sc serverConfig) error {
+	tinygo, err := m.isTinyGo()
+	if err != nil {
+		return fmt.Errorf("%s is not a valid WebAssembly module: %w", ws.wasmFile, err)
+	}
+	ws.tinygo = tinygo
+	if sc.WasmExecJS != "" {
+		buf, err := os.ReadFile(sc.WasmExecJS)
+		if err != nil {
+			return fmt.Errorf("error reading wasm_exec.js: %w", err)
+		}
+		ws.wasmExecJS = buf
+		return nil
+	}
+	if tinygo {
+		return ws.loadTinyGoWasmExec(sc.TinyGoRoot)
+	}
+
+	goVersion := m.goVersion()
+	if version.Lang(goVersion) == "" {
+		// Older and development releases do not say, hope for the best.
+		return ws.loadGOROOTWasmExec()
+	}
+	path, searched := findWasmExec(goVersion, sc.ToolchainDownload)
+	if path == "" {
+		hint := "set WASM_TOOLCHAIN_DOWNLOAD=on to let the go command download it, or "
+		if sc.ToolchainDownload {
+			hint = ""
+		}
+		return fmt.Errorf("cannot find the wasm_exec.js of %s, which built %s. Looked in:\n\t%s\nInstall %s, %sset WASM_EXEC_JS to its wasm_exec.js",
+			goVersion, ws.wasmFile, strings.Join(searched, "\n\t"), goVersion, hint)
+	}
+	ws.wasmExecJS, err = os.ReadFile(path)
+	return err
+}
+
+// loadGOROOTWasmExec loads the wasm_exec.js of the Go release which built
+// the runner.
+func (ws *wasmServer) loadGOROOTWasmExec() error {
+	var buf []byte
+	var err error
+	for _, loc := range wasmLocations {
+		buf, err = os.ReadFile(filepath.Join(runtime.GOROOT(), loc))
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err != nil {
+		var perr *os.PathError
+		if errors.As(err, &perr) {
+			if strings.Contains(perr.Path, filepath.Join("golang.org", "toolchain")) {
+				return fmt.Errorf("The Go toolchain does not include the WebAssembly exec helper before Go 1.24. Please copy wasm_exec.js to %s", filepath.Join(runtime.GOROOT(), "misc", "wasm", "wasm_exec.js"))
+			}
+		}
+		return err
+	}
+	ws.wasmExecJS = buf
+	return nil
+}
+
+// findWasmExec returns the wasm_exec.js of the release of goVersion, the
+// patch version does not matter. It looks in the GOROOT of the runner, of
+// the go command, of the toolchains in the module cache, and finally, with
+// download, of the toolchain the go command downloads for goVersion. It
+// also returns where it looked.
+func findWasmExec(goVersion string, download bool) (string, []string) {
+	release := version.Lang(goVersion)
+	var searched []string
+	try := func(v, root string) string {
+		if version.Lang(v) != release || root == "" {
+			return ""
+		}
+		for _, loc := range wasmLocations {
+			path := filepath.Join(root, filepath.FromSlash(loc))
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+			searched = append(searched, path)
+		}
+		return ""
+	}
+
+	if path := try(runtime.Version(), runtime.GOROOT()); path != "" {
+		return path, nil
+	}
+	if env, err := goEnv(""); err == nil {
+		if path := try(env.version, env.goroot); path != "" {
+			return path, nil
+		}
+		for _, tc := range moduleCacheToolchains(env.modcache, goVersion) {
+			if path := try(tc.version, tc.goroot); path != "" {
+				return path, nil
+			}
+		}
+	}
+	if !download {
+		return "", searched
+	}
+	env, err := goEnv(goVersion)
+	if err != nil {
+		return "", append(searched, fmt.Sprintf("the %s toolchain, which the go command could not download: %v", goVersion, err))
+	}
+	if path := try(env.version, env.goroot); path != "" {
+		return path, nil
+	}
+	return "", searched
+}
+
+// goEnvInfo is what go env says about a toolchain.
+type goEnvInfo struct {
+	version, goroot, modcache string
+}
+
+// goEnv runs go env, with the toolchain if it is set, which the go command
+// may download, or else with the local one. It is a variable for the tests.
+var goEnv = func(toolchain string) (goEnvInfo, error) {
+	cmd := exec.Command("go", "env", "GOVERSION", "GOROOT", "GOMODCACHE")
+	if toolchain == "" {
+		toolchain = "local"
+	}
+	cmd.Env = append(os.Environ(), "GOTOOLCHAIN="+toolchain)
+	out, err := cmd.Output()
+	if err != nil {
+		return goEnvInfo{}, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 3 {
+		return goEnvInfo{}, fmt.Errorf("unexpected go env output %q", out)
+	}
+	// Experiments follow the version, like go1.24.3 X:nocoverageredesign.
+	v, _, _ := strings.Cut(lines[0], " ")
+	return goEnvInfo{version: v, goroot: lines[1], modcache: lines[2]}, nil
+}
+
+type cachedToolchain struct {
+	version, goroot string
+}
+
+// moduleCacheToolchains returns the toolchains of the release of goVersion
+// which the go command downloaded to the module cache, the exact version
+// first, then the latest.
+func moduleCacheToolchains(modcache, goVersion string) []cachedToolchain {
+	if modcache == "" {
+		return nil
+	}
+	dirs, _ := filepath.Glob(filepath.Join(modcache, "golang.org", "toolchain@v0.0.1-"+version.Lang(goVersion)+"*"))
+	var toolchains []cachedToolchain
+	for _, dir := range dirs {
+		// Like toolchain@v0.0.1-go1.24.3.linux-amd64.
+		name := strings.TrimPrefix(filepath.Base(dir), "toolchain@v0.0.1-")
+		v := name
+		if i := strings.LastIndexByte(name, '.'); i >= 0 && strings.Contains(name[i:], "-") {
+			v = name[:i]
+		}
+		if version.Lang(v) == version.Lang(goVersion) {
+			toolchains = append(toolchains, cachedToolchain{version: v, goroot: dir})
+		}
+	}
+	sort.SliceStable(toolchains, func(i, j int) bool {
+		vi, vj := toolchains[i].version, toolchains[j].version
+		if (vi == goVersion) != (vj == goVersion) {
+			return vi == goVersion
+		}
+		return version.Compare(vi, vj) > 0
+	})
+	return toolchains
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stubGoEnv makes goEnv answer from envs, by toolchain, and records the
+// toolchains it was asked for.
+func stubGoEnv(t *testing.T, envs map[string]goEnvInfo) *[]string {
+	t.Helper()
+	var calls []string
+	orig := goEnv
+	goEnv = func(toolchain string) (goEnvInfo, error) {
+		calls = append(calls, toolchain)
+		env, ok := envs[toolchain]
+		if !ok {
+			return goEnvInfo{}, os.ErrNotExist
+		}
+		return env, nil
+	}
+	t.Cleanup(func() { goEnv = orig })
+	return &calls
+}
+
+func writeWasmExec(t *testing.T, root, loc string) string {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(loc))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("// "+path), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFindWasmExec(t *testing.T) {
+	modcache := t.TempDir()
+	toolchains := filepath.Join(modcache, "golang.org")
+	writeWasmExec(t, filepath.Join(toolchains, "toolchain@v0.0.1-go1.21.3.linux-amd64"), "misc/wasm/wasm_exec.js")
+	latest := writeWasmExec(t, filepath.Join(toolchains, "toolchain@v0.0.1-go1.21.10.linux-amd64"), "misc/wasm/wasm_exec.js")
+	writeWasmExec(t, filepath.Join(toolchains, "toolchain@v0.0.1-go1.2.2.linux-amd64"), "misc/wasm/wasm_exec.js")
+	downloaded := filepath.Join(t.TempDir(), "go1.22.1")
+	downloadedJS := writeWasmExec(t, downloaded, "misc/wasm/wasm_exec.js")
+
+	calls := stubGoEnv(t, map[string]goEnvInfo{
+		"":         {version: "go1.20.1", goroot: "/nonexistent", modcache: modcache},
+		"go1.22.1": {version: "go1.22.1", goroot: downloaded, modcache: modcache},
+	})
+
+	path, _ := findWasmExec("go1.21.5", false)
+	if path != latest {
+		t.Errorf("got %s, expected the latest cached toolchain %s", path, latest)
+	}
+	if strings.Join(*calls, ",") != "" {
+		t.Errorf("go env was called for toolchains %q, expected only the go command", *calls)
+	}
+
+	exact := writeWasmExec(t, filepath.Join(toolchains, "toolchain@v0.0.1-go1.21.5.linux-amd64"), "lib/wasm/wasm_exec.js")
+	if path, _ := findWasmExec("go1.21.5", false); path != exact {
+		t.Errorf("got %s, expected the exact cached toolchain %s", path, exact)
+	}
+
+	if path, _ := findWasmExec("go1.22.1", false); path != "" {
+		t.Errorf("got %s, expected none without downloads", path)
+	}
+	if slices.Contains(*calls, "go1.22.1") {
+		t.Errorf("go env was called for toolchains %q without downloads", *calls)
+	}
+	if path, _ := findWasmExec("go1.22.1", true); path != downloadedJS {
+		t.Errorf("got %s, expected the downloaded toolchain %s", path, downloadedJS)
+	}
+
+	_, searched := findWasmExec("go1.20.8", true)
+	if last := searched[len(searched)-1]; !strings.HasPrefix(last, "the go1.20.8 toolchain, which the go command could not download") {
+		t.Errorf("searched %q, expected the failed download last", searched)
+	}
+
+	path, searched = findWasmExec("go1.20.7", false)
+	if path != "" {
+		t.Errorf("got %s, expected none", path)
+	}
+	expected := []string{
+		filepath.Join("/nonexistent", "misc", "wasm", "wasm_exec.js"),
+		filepath.Join("/nonexistent", "lib", "wasm", "wasm_exec.js"),
+	}
+	if strings.Join(searched, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("searched %q, expected %q", searched, expected)
+	}
+}
+
+func TestLoadWasmExec(t *testing.T) {
+	dir := t.TempDir()
+	m, err := parseWasmModule(wasmTestModule(wasmTestProducers("go1.9.1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubGoEnv(t, nil)
+	ws := &wasmServer{wasmFile: "test.wasm", logger: log.New(io.Discard, "", 0)}
+
+	err = ws.loadWasmExec(m, serverConfig{})
+	if err == nil || !strings.HasPrefix(err.Error(), "cannot find the wasm_exec.js of go1.9.1, which built test.wasm") ||
+		!strings.Contains(err.Error(), "set WASM_TOOLCHAIN_DOWNLOAD=on") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	override := writeWasmExec(t, dir, "wasm_exec.js")
+	if err := ws.loadWasmExec(m, serverConfig{WasmExecJS: override}); err != nil {
+		t.Fatal(err)
+	}
+	if string(ws.wasmExecJS) != "// "+override {
+		t.Errorf("unexpected wasm_exec.js %q", ws.wasmExecJS)
+	}
+
+	// The version of older binaries is unknown.
+	m, err = readWasmModule("testdata/test.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.loadWasmExec(m, serverConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ws.wasmExecJS) == 0 {
+		t.Error("wasm_exec.js is empty")
+	}
+}